@@ -0,0 +1,56 @@
+package gothic
+
+import "github.com/markbates/goth"
+
+// Alias returns p registered under name, so that several instances of the
+// same provider type, such as github.com and a GitHub Enterprise
+// installation, can be used side by side.
+//
+// The returned provider reports name from Name and in goth.User.Provider.
+// Use ProviderType to get the name of the underlying provider.
+func Alias(name string, p goth.Provider) goth.Provider {
+	return &aliasProvider{Provider: p, name: name}
+}
+
+// ProviderType returns the name of the provider behind any aliases of p.
+func ProviderType(p goth.Provider) string {
+	return unalias(p).Name()
+}
+
+// unalias returns the provider behind any aliases of p.
+// Sessions may assert the concrete type of the provider passed to Authorize.
+func unalias(p goth.Provider) goth.Provider {
+	for {
+		a, ok := p.(*aliasProvider)
+		if !ok {
+			return p
+		}
+		p = a.Provider
+	}
+}
+
+type aliasProvider struct {
+	goth.Provider
+	name string
+}
+
+func (p *aliasProvider) Name() string {
+	return p.name
+}
+
+func (p *aliasProvider) Protocol() Protocol {
+	return protocol(p.Provider.Name(), p.Provider)
+}
+
+func (p *aliasProvider) FetchUser(s goth.Session) (goth.User, error) {
+	u, err := p.Provider.FetchUser(s)
+	u.Provider = p.name
+	return u, err
+}
+
+func (p *aliasProvider) BeginAuthWithCallback(state, callbackURL string) (goth.Session, error) {
+	if cp, ok := p.Provider.(CallbackProvider); ok {
+		return cp.BeginAuthWithCallback(state, callbackURL)
+	}
+	return p.Provider.BeginAuth(state)
+}
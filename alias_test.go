@@ -0,0 +1,41 @@
+package gothic
+
+import "testing"
+
+func TestAlias(t *testing.T) {
+	ghe := Alias("ghe", &mockProvider{})
+	if ghe.Name() != "ghe" {
+		t.Errorf("expected name %q got %q", "ghe", ghe.Name())
+	}
+	if typ := ProviderType(ghe); typ != providerName {
+		t.Errorf("expected type %q got %q", providerName, typ)
+	}
+	if typ := ProviderType(Alias("ghe2", ghe)); typ != providerName {
+		t.Errorf("expected type %q got %q", providerName, typ)
+	}
+	if p := protocol("ghe", Alias("ghe", &mockOAuth1Provider{})); p != OAuth1 {
+		t.Errorf("expected protocol %d got %d", OAuth1, p)
+	}
+	if p := protocol("tw", Alias("tw", &namedProvider{name: "twitter"})); p != stateUnsupported {
+		t.Errorf("expected protocol %d got %d", stateUnsupported, p)
+	}
+}
+
+func TestAliasFlow(t *testing.T) {
+	g := &Gothic{Providers: NewRegistry(Alias("ghe", &mockProvider{}))}
+
+	cookie := gothicCookie(g, "ghe")
+	w, r := wr("GET", "/?state="+lastState, nil)
+	r.Header.Set("Cookie", cookie)
+	if _, err := g.CompleteAuth(providerName, w, r); err == nil {
+		t.Error("expected a flow started with another provider to be rejected")
+	}
+
+	w, r = wr("GET", "/?state="+lastState, nil)
+	r.Header.Set("Cookie", cookie)
+	user, err := g.CompleteAuth("ghe", w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifyUserWith(t, "ghe", user)
+}
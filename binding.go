@@ -0,0 +1,162 @@
+package gothic
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/securecookie"
+)
+
+// Binding is a set of attributes of the browser which a flow is bound to.
+// CompleteAuth rejects callbacks from browsers whose attributes differ from
+// the ones of the browser which started the flow.
+type Binding int
+
+const (
+	// BindUserAgent binds flows to the User-Agent header.
+	BindUserAgent Binding = 1 << iota
+	// BindIPPrefix binds flows to the network of the client address, see
+	// BindingIPv4Prefix and BindingIPv6Prefix. Behind proxies, the address
+	// is only as reliable as TrustedProxies and their Header.
+	BindIPPrefix
+	// BindCookie binds flows to a long-lived random cookie of the browser.
+	BindCookie
+)
+
+func (b Binding) String() string {
+	var names []string
+	for _, a := range bindingAttrs {
+		if b&a.binding != 0 {
+			names = append(names, a.name)
+		}
+	}
+	return strings.Join(names, "|")
+}
+
+// FlowBinding is the binding of every flow, in addition to Gothic.Binding.
+var FlowBinding Binding
+
+// BindingIPv4Prefix and BindingIPv6Prefix are the lengths of the network
+// prefixes compared by BindIPPrefix, which tolerate address changes within
+// the network of the client.
+var (
+	BindingIPv4Prefix = 24
+	BindingIPv6Prefix = 48
+)
+
+// BindingCookieMaxAge is the lifetime of the cookie of BindCookie in seconds.
+var BindingCookieMaxAge = 365 * 24 * 60 * 60
+
+// BindingError is returned by CompleteAuth when the callback comes from
+// another browser than the one which started the flow.
+type BindingError struct {
+	// Mismatch is the set of attributes which do not match.
+	Mismatch Binding
+}
+
+func (e *BindingError) Error() string {
+	return "gothic: flow was started by another browser (" + e.Mismatch.String() + " mismatch)"
+}
+
+var bindingAttrs = []struct {
+	binding Binding
+	name    string
+}{
+	{BindUserAgent, "user-agent"},
+	{BindIPPrefix, "ip"},
+	{BindCookie, "cookie"},
+}
+
+const bindingExtraPrefix = "bind."
+
+func (g *Gothic) binding() Binding {
+	return g.Binding | FlowBinding
+}
+
+func (g *Gothic) bindingCookieName() string {
+	return g.cookieName() + "_browser"
+}
+
+// bind records the attributes of r selected by g in p, setting the cookie of
+// BindCookie to w when r has none.
+func (g *Gothic) bind(p *payload, w http.ResponseWriter, r *http.Request, co Options) {
+	b := g.binding()
+	if b == 0 {
+		return
+	}
+	if b&BindCookie != 0 {
+		if c, err := r.Cookie(g.bindingCookieName()); err != nil || c.Value == "" {
+			co.MaxAge = BindingCookieMaxAge
+			value := base64.RawURLEncoding.EncodeToString(securecookie.GenerateRandomKey(24))
+			http.SetCookie(w, cookie(g.bindingCookieName(), value, &co))
+			// the callback must send the new cookie back
+			r = r.Clone(r.Context())
+			r.AddCookie(&http.Cookie{Name: g.bindingCookieName(), Value: value})
+		}
+	}
+	if p.Extras == nil {
+		p.Extras = map[string]string{}
+	}
+	for _, a := range bindingAttrs {
+		if b&a.binding != 0 {
+			p.Extras[bindingExtraPrefix+a.name] = g.bindingHash(a.binding, r)
+		}
+	}
+}
+
+// verifyBinding compares the attributes recorded in p with r. Attributes
+// which are not recorded are not compared, so flows started before enabling
+// a binding still complete.
+func (g *Gothic) verifyBinding(p *payload, r *http.Request) error {
+	var mismatch Binding
+	for _, a := range bindingAttrs {
+		want, ok := p.Extras[bindingExtraPrefix+a.name]
+		if ok && g.bindingHash(a.binding, r) != want {
+			mismatch |= a.binding
+		}
+	}
+	if mismatch != 0 {
+		return &BindingError{Mismatch: mismatch}
+	}
+	return nil
+}
+
+// bound reports whether the attribute b is recorded in p.
+func (p *payload) bound(b Binding) bool {
+	for _, a := range bindingAttrs {
+		if a.binding == b {
+			_, ok := p.Extras[bindingExtraPrefix+a.name]
+			return ok
+		}
+	}
+	return false
+}
+
+func (g *Gothic) bindingHash(b Binding, r *http.Request) string {
+	var v string
+	switch b {
+	case BindUserAgent:
+		v = r.UserAgent()
+	case BindIPPrefix:
+		v = ipPrefix(g.proxies().ClientIP(r))
+	case BindCookie:
+		if c, err := r.Cookie(g.bindingCookieName()); err == nil {
+			v = c.Value
+		}
+	}
+	h := sha256.Sum256([]byte(v))
+	return base64.RawURLEncoding.EncodeToString(h[:12])
+}
+
+func ipPrefix(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(BindingIPv4Prefix, 8*net.IPv4len)).String()
+	}
+	return ip.Mask(net.CIDRMask(BindingIPv6Prefix, 8*net.IPv6len)).String()
+}
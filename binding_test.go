@@ -0,0 +1,116 @@
+package gothic
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestBinding(t *testing.T) {
+	ps, _ := ParseProxies("192.0.2.0/24")
+	g := &Gothic{Binding: BindUserAgent | BindIPPrefix | BindCookie, TrustedProxies: ps}
+
+	w, r := wr("GET", "/auth/"+providerName, nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	r.Header.Set("X-Forwarded-For", "198.51.100.7, 192.0.2.9")
+	r.Header.Set("User-Agent", "browser/1")
+	if err := g.BeginAuth(providerName, w, r); err != nil {
+		t.Fatal(err)
+	}
+	state := lastState
+	cookies := w.Result().Cookies()
+	if len(cookies) != 2 || cookies[0].Name != g.bindingCookieName() || cookies[0].MaxAge != BindingCookieMaxAge {
+		t.Fatalf("expected the browser cookie and the flow cookie got %v", cookies)
+	}
+
+	complete := func(ua, ip string, cookies []*http.Cookie) error {
+		w, r := wr("GET", "/auth/"+providerName+"/callback?state="+state, nil)
+		r.RemoteAddr = "192.0.2.1:1234"
+		r.Header.Set("X-Forwarded-For", ip)
+		r.Header.Set("User-Agent", ua)
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		_, err := g.CompleteAuth(providerName, w, r)
+		return err
+	}
+
+	for _, c := range []struct {
+		ua, ip   string
+		cookies  []*http.Cookie
+		mismatch Binding
+	}{
+		{"browser/2", "198.51.100.7", cookies, BindUserAgent},
+		{"browser/1", "203.0.113.7", cookies, BindIPPrefix},
+		{"browser/1", "198.51.100.7, 203.0.113.7", cookies, BindIPPrefix},
+		{"browser/1", "198.51.100.7", cookies[1:], BindCookie},
+		{"browser/2", "203.0.113.7", cookies[1:], BindUserAgent | BindIPPrefix | BindCookie},
+	} {
+		var be *BindingError
+		if err := complete(c.ua, c.ip, c.cookies); !errors.As(err, &be) || be.Mismatch != c.mismatch {
+			t.Errorf("expected a %v mismatch got %v", c.mismatch, err)
+		}
+	}
+
+	// the Forwarded header is passed through from the client by the proxies
+	// setting X-Forwarded-For
+	w, r = wr("GET", "/auth/"+providerName+"/callback?state="+state, nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	r.Header.Set("X-Forwarded-For", "203.0.113.7")
+	r.Header.Set("Forwarded", "for=198.51.100.7")
+	r.Header.Set("User-Agent", "browser/1")
+	for _, c := range cookies {
+		r.AddCookie(c)
+	}
+	var be *BindingError
+	if _, err := g.CompleteAuth(providerName, w, r); !errors.As(err, &be) || be.Mismatch != BindIPPrefix {
+		t.Errorf("expected a %v mismatch got %v", BindIPPrefix, err)
+	}
+
+	// the client may move within its network
+	if err := complete("browser/1", "198.51.100.200", cookies); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBindingReusesCookie(t *testing.T) {
+	g := &Gothic{Binding: BindCookie}
+	w, r := wr("GET", "/auth/"+providerName, nil)
+	r.AddCookie(&http.Cookie{Name: g.bindingCookieName(), Value: "browser"})
+	if err := g.BeginAuth(providerName, w, r); err != nil {
+		t.Fatal(err)
+	}
+	if cookies := w.Result().Cookies(); len(cookies) != 1 || cookies[0].Name != g.cookieName() {
+		t.Errorf("expected only the flow cookie got %v", cookies)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	xf, _ := ParseProxies("192.0.2.0/24")
+	fw := Proxies{Networks: xf.Networks, Header: ForwardedHeader}
+	for _, c := range []struct {
+		ps      Proxies
+		remote  string
+		headers map[string]string
+		want    string
+	}{
+		{xf, "198.51.100.1:1", nil, "198.51.100.1"},
+		{xf, "198.51.100.1:1", map[string]string{"X-Forwarded-For": "203.0.113.1"}, "198.51.100.1"},
+		// the leading entries are forged by the client
+		{xf, "192.0.2.1:1", map[string]string{"X-Forwarded-For": "1.2.3.4, 203.0.113.1, 192.0.2.2"}, "203.0.113.1"},
+		{fw, "192.0.2.1:1", map[string]string{"Forwarded": `for=1.2.3.4, for=203.0.113.1, for=192.0.2.2`}, "203.0.113.1"},
+		{fw, "192.0.2.1:1", map[string]string{"Forwarded": `for="[2001:db8::1]:4711";proto=https, for=192.0.2.2`}, "2001:db8::1"},
+		// the headers of the other family are passed through from the client
+		{xf, "192.0.2.1:1", map[string]string{"Forwarded": "for=1.2.3.4", "X-Forwarded-For": "203.0.113.1"}, "203.0.113.1"},
+		{fw, "192.0.2.1:1", map[string]string{"Forwarded": "for=203.0.113.1", "X-Forwarded-For": "1.2.3.4"}, "203.0.113.1"},
+	} {
+		_, r := wr("GET", "/", nil)
+		r.RemoteAddr = c.remote
+		for k, v := range c.headers {
+			r.Header.Set(k, v)
+		}
+		if got := c.ps.ClientIP(r).String(); got != c.want {
+			t.Errorf("%v: expected %s got %s", c.headers, c.want, got)
+		}
+	}
+}
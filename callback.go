@@ -0,0 +1,122 @@
+package gothic
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/markbates/goth"
+)
+
+// Callbacks is the CallbackCache used by CompleteAuth.
+// A nil Callbacks disables the detection of duplicate callbacks.
+var Callbacks *CallbackCache
+
+// ErrCallbackInProgress is returned by CompleteAuth for a duplicate callback
+// when the first one does not complete within the window of the
+// CallbackCache.
+var ErrCallbackInProgress = errors.New("gothic: the callback is still being completed")
+
+// ErrCallbackCompleted is returned by CompleteAuth for a duplicate callback
+// without the flow cookie when the first one succeeded, unless the flow is
+// bound to the browser cookie of BindCookie.
+var ErrCallbackCompleted = errors.New("gothic: the callback was already completed")
+
+// CallbackCache remembers the outcome of each callback for a short window, so
+// a duplicate callback for the same flow, sent twice by the browser or a
+// prefetcher, returns the outcome of the first one instead of failing.
+//
+// Callbacks are identified by the provider, the tenant, the state and the
+// client address and user agent, and are only remembered once their state is
+// verified with the flow cookie. A duplicate callback gets the outcome only
+// when it passes the browser binding of the flow, and gets the user only when
+// the flow is bound with BindCookie: the callback URL may leak through the
+// Referer header or logs to others sharing the address and user agent.
+//
+// The zero value is ready to use and remembers outcomes for 10 seconds.
+type CallbackCache struct {
+	window time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*callbackEntry
+}
+
+type callbackEntry struct {
+	// flow is the payload of the first callback, whose binding is verified
+	// for the duplicate callbacks.
+	flow   *payload
+	done   chan struct{}
+	user   goth.User
+	err    error
+	expiry time.Time
+}
+
+const defaultCallbackWindow = 10 * time.Second
+
+// NewCallbackCache returns a new CallbackCache remembering outcomes for window.
+func NewCallbackCache(window time.Duration) *CallbackCache {
+	return &CallbackCache{window: window}
+}
+
+func (c *CallbackCache) windowOrDefault() time.Duration {
+	if c.window > 0 {
+		return c.window
+	}
+	return defaultCallbackWindow
+}
+
+// lookup returns the entry of key if it is in progress or remembered.
+func (c *CallbackCache) lookup(key string) *callbackEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.prune()
+	return c.entries[key]
+}
+
+// start registers key and reports whether the caller is the first one.
+// Otherwise the entry of the first one is returned.
+func (c *CallbackCache) start(key string, flow *payload) (*callbackEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.prune()
+	if e, ok := c.entries[key]; ok {
+		return e, false
+	}
+	if c.entries == nil {
+		c.entries = map[string]*callbackEntry{}
+	}
+	e := &callbackEntry{flow: flow, done: make(chan struct{})}
+	c.entries[key] = e
+	return e, true
+}
+
+func (c *CallbackCache) finish(e *callbackEntry, user goth.User, err error) {
+	c.mu.Lock()
+	e.user, e.err = user, err
+	e.expiry = time.Now().Add(c.windowOrDefault())
+	c.mu.Unlock()
+	close(e.done)
+}
+
+func (c *CallbackCache) wait(e *callbackEntry) (goth.User, error) {
+	t := time.NewTimer(c.windowOrDefault())
+	defer t.Stop()
+	select {
+	case <-e.done:
+	case <-t.C:
+		return goth.User{}, ErrCallbackInProgress
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return e.user, e.err
+}
+
+// prune deletes the expired entries. c.mu must be held.
+func (c *CallbackCache) prune() {
+	now := time.Now()
+	for k, e := range c.entries {
+		if !e.expiry.IsZero() && !now.Before(e.expiry) {
+			delete(c.entries, k)
+		}
+	}
+}
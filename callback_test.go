@@ -0,0 +1,169 @@
+package gothic
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/markbates/goth"
+)
+
+type slowProvider struct {
+	mockProvider
+	fetched int32
+}
+
+func (p *slowProvider) FetchUser(s goth.Session) (goth.User, error) {
+	atomic.AddInt32(&p.fetched, 1)
+	time.Sleep(50 * time.Millisecond)
+	return p.mockProvider.FetchUser(s)
+}
+
+func TestCompleteAuthDuplicateCallback(t *testing.T) {
+	g := &Gothic{Callbacks: NewCallbackCache(time.Minute), States: NewMemoryStateStore()}
+	cookie := gothicCookie(g, providerName)
+	state := lastState
+
+	complete := func(ua, cookie string) (goth.User, error) {
+		w, r := wr("GET", "/?state="+state, nil)
+		r.Header.Set("User-Agent", ua)
+		if cookie != "" {
+			r.Header.Set("Cookie", cookie)
+		}
+		return g.CompleteAuth(providerName, w, r)
+	}
+
+	user, err := complete("a", cookie)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifyUser(t, user)
+
+	// the browser sends the callback again, without the deleted cookie, but
+	// the leaked callback URL would get the user anywhere behind the address
+	if _, err = complete("a", ""); err != ErrCallbackCompleted {
+		t.Errorf("expected %v got %v", ErrCallbackCompleted, err)
+	}
+
+	// another client does not get the outcome
+	var ce *FlowCookieError
+	if _, err = complete("b", ""); !errors.As(err, &ce) {
+		t.Errorf("expected a FlowCookieError got %v", err)
+	}
+	if _, err = complete("b", cookie); err != ErrStateReused {
+		t.Errorf("expected %v got %v", ErrStateReused, err)
+	}
+}
+
+func TestCallbackCacheZeroValue(t *testing.T) {
+	g := &Gothic{Callbacks: &CallbackCache{}, Binding: BindCookie}
+	w, r := wr("GET", "/auth/"+providerName, nil)
+	if err := g.BeginAuth(providerName, w, r); err != nil {
+		t.Fatal(err)
+	}
+	state := lastState
+	cookies := w.Result().Cookies()
+	for i, cookies := range [][]*http.Cookie{cookies, cookies[:1]} {
+		w, r := wr("GET", "/?state="+state, nil)
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		user, err := g.CompleteAuth(providerName, w, r)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		verifyUser(t, user)
+	}
+}
+
+func TestCompleteAuthConcurrentCallbacks(t *testing.T) {
+	p := &slowProvider{}
+	g := &Gothic{Callbacks: NewCallbackCache(time.Minute), Providers: NewRegistry(p)}
+	cookie := gothicCookie(g, providerName)
+	state := lastState
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w, r := wr("GET", "/?state="+state, nil)
+			r.Header.Set("Cookie", cookie)
+			user, err := g.CompleteAuth(providerName, w, r)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			verifyUser(t, user)
+		}()
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&p.fetched); n != 1 {
+		t.Errorf("expected the flow to complete once got %d", n)
+	}
+}
+
+func TestCompleteAuthFailedCallbackNotCached(t *testing.T) {
+	g := &Gothic{Callbacks: NewCallbackCache(time.Minute)}
+	cookie := gothicCookie(g, providerName)
+	state := lastState
+
+	// a callback without the cookie, such as of a prefetcher, is not remembered
+	w, r := wr("GET", "/?state="+state, nil)
+	if _, err := g.CompleteAuth(providerName, w, r); err == nil {
+		t.Fatal("expected an error without the cookie")
+	}
+	w, r = wr("GET", "/?state="+state, nil)
+	r.Header.Set("Cookie", cookie)
+	user, err := g.CompleteAuth(providerName, w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifyUser(t, user)
+}
+
+func TestCompleteAuthDuplicateCallbackBinding(t *testing.T) {
+	ps, _ := ParseProxies("192.0.2.0/24")
+	g := &Gothic{Callbacks: NewCallbackCache(time.Minute), Binding: BindCookie, TrustedProxies: ps}
+	w, r := wr("GET", "/auth/"+providerName, nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+	if err := g.BeginAuth(providerName, w, r); err != nil {
+		t.Fatal(err)
+	}
+	state := lastState
+	cookies := w.Result().Cookies()
+
+	complete := func(client string, cookies ...*http.Cookie) (goth.User, error) {
+		w, r := wr("GET", "/?state="+state, nil)
+		r.RemoteAddr = "192.0.2.1:5678"
+		r.Header.Set("X-Forwarded-For", client)
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		return g.CompleteAuth(providerName, w, r)
+	}
+
+	if _, err := complete("198.51.100.1", cookies...); err != nil {
+		t.Fatal(err)
+	}
+	user, err := complete("198.51.100.1", cookies[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifyUser(t, user)
+
+	// the same address and user agent with another browser cookie
+	var be *BindingError
+	other := &http.Cookie{Name: g.bindingCookieName(), Value: "other"}
+	if _, err = complete("198.51.100.1", other); !errors.As(err, &be) {
+		t.Errorf("expected a BindingError got %v", err)
+	}
+	// another client behind the same proxy
+	if _, err = complete("198.51.100.2", cookies[0]); err == nil {
+		t.Error("expected another client not to get the outcome")
+	}
+}
@@ -0,0 +1,114 @@
+package gothic
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ErrCookieTooLarge is returned by GetAuthURL when the encoded flow exceeds
+// the maximum cookie size.
+var ErrCookieTooLarge = errors.New("gothic: flow cookie exceeds the maximum size")
+
+// MaxCookieSize is the maximum total size of the encoded flow, which is split
+// across numbered cookies when it does not fit in a single browser cookie.
+var MaxCookieSize = 4 * cookieChunkSize
+
+// cookieChunkSize is the size of the value of each cookie, which leaves room
+// for the name and attributes within the 4096 bytes browsers accept.
+const cookieChunkSize = 3800
+
+// chunkCookieName returns the name of the i-th chunk cookie.
+// The first chunk uses name itself.
+func chunkCookieName(name string, i int) string {
+	if i == 0 {
+		return name
+	}
+	return name + "." + strconv.Itoa(i)
+}
+
+// setChunkedCookie sets value to the cookie name, splitting it into chunks
+// when it is too large. The first chunk is prefixed by the number of chunks
+// and a dot. Values of a Codec may contain dots, but never start with digits
+// followed by a dot.
+// Stale chunks of a previous flow sent in r are deleted.
+func setChunkedCookie(w http.ResponseWriter, r *http.Request, name, value string, opt *Options, max int) error {
+	if len(value) > max {
+		return ErrCookieTooLarge
+	}
+
+	n := (len(value) + cookieChunkSize - 1) / cookieChunkSize
+	if n <= 1 {
+		http.SetCookie(w, cookie(name, value, opt))
+		deleteChunkCookies(w, r, name, 1, opt)
+		return nil
+	}
+
+	for i := 0; i < n; i++ {
+		v := value[i*cookieChunkSize:]
+		if len(v) > cookieChunkSize {
+			v = v[:cookieChunkSize]
+		}
+		if i == 0 {
+			v = strconv.Itoa(n) + "." + v
+		}
+		http.SetCookie(w, cookie(chunkCookieName(name, i), v, opt))
+	}
+	deleteChunkCookies(w, r, name, n, opt)
+	return nil
+}
+
+// readChunkedCookie returns the value set by setChunkedCookie.
+func readChunkedCookie(r *http.Request, name string, max int) (string, error) {
+	c, err := r.Cookie(name)
+	if err != nil {
+		return "", err
+	}
+	dot := strings.IndexByte(c.Value, '.')
+	if dot == -1 {
+		return c.Value, nil
+	}
+
+	n, err := strconv.Atoi(c.Value[:dot])
+	if err != nil {
+		return c.Value, nil
+	}
+	if n < 2 || n > (max+cookieChunkSize-1)/cookieChunkSize {
+		return "", ErrCookieTooLarge
+	}
+	chunks := []string{c.Value[dot+1:]}
+	for i := 1; i < n; i++ {
+		c, err := r.Cookie(chunkCookieName(name, i))
+		if err != nil {
+			return "", fmt.Errorf("gothic: flow cookie chunk %d of %d is missing", i, n)
+		}
+		chunks = append(chunks, c.Value)
+	}
+	return strings.Join(chunks, ""), nil
+}
+
+// deleteChunkedCookie deletes the cookie name and all of its chunks sent in r.
+func deleteChunkedCookie(w http.ResponseWriter, r *http.Request, name string, opt *Options) {
+	co := *opt
+	co.MaxAge = -1
+	http.SetCookie(w, cookie(name, "", &co))
+	deleteChunkCookies(w, r, name, 1, opt)
+}
+
+// deleteChunkCookies deletes the chunks of the cookie name from the from-th
+// chunk which are sent in r.
+func deleteChunkCookies(w http.ResponseWriter, r *http.Request, name string, from int, opt *Options) {
+	co := *opt
+	co.MaxAge = -1
+	prefix := name + "."
+	for _, c := range r.Cookies() {
+		if !strings.HasPrefix(c.Name, prefix) {
+			continue
+		}
+		if i, err := strconv.Atoi(c.Name[len(prefix):]); err == nil && i >= from {
+			http.SetCookie(w, cookie(c.Name, "", &co))
+		}
+	}
+}
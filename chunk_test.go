@@ -0,0 +1,123 @@
+package gothic
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/securecookie"
+	"github.com/markbates/goth"
+)
+
+type bigProvider struct {
+	mockProvider
+	size int
+}
+
+func (p *bigProvider) Name() string {
+	return "big"
+}
+
+func (p *bigProvider) BeginAuth(state string) (goth.Session, error) {
+	s, err := p.mockProvider.BeginAuth(state)
+	s.(*mockSession).Padding = base64.StdEncoding.EncodeToString(securecookie.GenerateRandomKey(p.size))
+	return s, err
+}
+
+func (p *bigProvider) FetchUser(s goth.Session) (goth.User, error) {
+	u, err := p.mockProvider.FetchUser(s)
+	u.Provider = p.Name()
+	return u, err
+}
+
+func beginBigAuth(t *testing.T, g *Gothic) []*http.Cookie {
+	w, r := wr("GET", "/", nil)
+	if err := g.BeginAuth("big", w, r); err != nil {
+		t.Fatal(err)
+	}
+	return w.Result().Cookies()
+}
+
+func TestChunkedCookie(t *testing.T) {
+	g := &Gothic{Providers: NewRegistry(&bigProvider{size: 4000})}
+	cookies := beginBigAuth(t, g)
+	if len(cookies) < 3 {
+		t.Fatalf("expected the flow to be split into chunks got %d cookies", len(cookies))
+	}
+	for i, c := range cookies {
+		if c.Name != chunkCookieName(CookieName, i) {
+			t.Errorf("expected cookie %q got %q", chunkCookieName(CookieName, i), c.Name)
+		}
+		if len(c.Value) > cookieChunkSize+2 {
+			t.Errorf("%s: expected at most %d bytes got %d", c.Name, cookieChunkSize+2, len(c.Value))
+		}
+	}
+
+	w, r := wr("GET", "/?state="+lastState, nil)
+	for _, c := range cookies {
+		r.AddCookie(c)
+	}
+	user, err := g.CompleteAuth("big", w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifyUserWith(t, "big", user)
+
+	deleted := map[string]bool{}
+	for _, c := range w.Result().Cookies() {
+		deleted[c.Name] = c.MaxAge < 0
+	}
+	for _, c := range cookies {
+		if !deleted[c.Name] {
+			t.Errorf("expected %s to be deleted", c.Name)
+		}
+	}
+}
+
+func TestChunkedCookieMissingChunk(t *testing.T) {
+	g := &Gothic{Providers: NewRegistry(&bigProvider{size: 4000})}
+	cookies := beginBigAuth(t, g)
+
+	w, r := wr("GET", "/?state="+lastState, nil)
+	for _, c := range cookies[:len(cookies)-1] {
+		r.AddCookie(c)
+	}
+	_, err := g.CompleteAuth("big", w, r)
+	if err == nil {
+		t.Fatal("expected error got none")
+	}
+	if !strings.Contains(err.Error(), "is missing") {
+		t.Errorf("expected missing chunk error got %q", err)
+	}
+}
+
+func TestChunkedCookieTooLarge(t *testing.T) {
+	g := &Gothic{Providers: NewRegistry(&bigProvider{size: 4000}), MaxCookieSize: 2 * cookieChunkSize}
+	w, r := wr("GET", "/", nil)
+	if err := g.BeginAuth("big", w, r); err != ErrCookieTooLarge {
+		t.Errorf("expected %v got %v", ErrCookieTooLarge, err)
+	}
+
+	cookies := beginBigAuth(t, &Gothic{Providers: g.Providers})
+	w, r = wr("GET", "/?state="+lastState, nil)
+	for _, c := range cookies {
+		r.AddCookie(c)
+	}
+	if _, err := g.CompleteAuth("big", w, r); err != ErrCookieTooLarge {
+		t.Errorf("expected %v got %v", ErrCookieTooLarge, err)
+	}
+}
+
+func TestChunkedCookieStaleChunks(t *testing.T) {
+	w, r := wr("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: CookieName + ".1", Value: "stale"})
+	r.AddCookie(&http.Cookie{Name: CookieName + ".x", Value: "unrelated"})
+	if err := BeginAuth(providerName, w, r); err != nil {
+		t.Fatal(err)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 2 || cookies[1].Name != CookieName+".1" || cookies[1].MaxAge >= 0 {
+		t.Errorf("expected the stale chunk to be deleted got %v", cookies)
+	}
+}
@@ -0,0 +1,115 @@
+package gothic
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/markbates/goth"
+)
+
+// ClaimMapping maps the fields of a userinfo response to goth.User.
+//
+// Each path is either a JSON pointer such as "/data/emails/0" or a dotted
+// path such as "data.emails.0". An empty path leaves the field empty.
+type ClaimMapping struct {
+	UserID      string
+	Email       string
+	Name        string
+	NickName    string
+	Description string
+	AvatarURL   string
+	Location    string
+	// RawData maps keys of goth.User.RawData to paths.
+	// When nil, RawData holds the whole response.
+	RawData map[string]string
+}
+
+// StandardClaims maps the standard claims of OpenID Connect.
+var StandardClaims = ClaimMapping{
+	UserID:    "sub",
+	Email:     "email",
+	Name:      "name",
+	NickName:  "preferred_username",
+	AvatarURL: "picture",
+	Location:  "address.locality",
+}
+
+// Apply sets the fields of u from claims.
+func (m *ClaimMapping) Apply(claims map[string]interface{}, u *goth.User) {
+	u.UserID = claimString(claims, m.UserID)
+	u.Email = claimString(claims, m.Email)
+	u.Name = claimString(claims, m.Name)
+	u.NickName = claimString(claims, m.NickName)
+	u.Description = claimString(claims, m.Description)
+	u.AvatarURL = claimString(claims, m.AvatarURL)
+	u.Location = claimString(claims, m.Location)
+
+	if m.RawData == nil {
+		u.RawData = claims
+		return
+	}
+	u.RawData = make(map[string]interface{}, len(m.RawData))
+	for k, path := range m.RawData {
+		if v, ok := lookupClaim(claims, path); ok {
+			u.RawData[k] = v
+		}
+	}
+}
+
+func claimString(claims map[string]interface{}, path string) string {
+	v, ok := lookupClaim(claims, path)
+	if !ok {
+		return ""
+	}
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// lookupClaim returns the value at path in v.
+func lookupClaim(v interface{}, path string) (interface{}, bool) {
+	if path == "" {
+		return nil, false
+	}
+
+	var keys []string
+	if path[0] == '/' {
+		keys = strings.Split(path[1:], "/")
+		for i, k := range keys {
+			keys[i] = strings.Replace(strings.Replace(k, "~1", "/", -1), "~0", "~", -1)
+		}
+	} else {
+		keys = strings.Split(path, ".")
+	}
+
+	for _, k := range keys {
+		switch vv := v.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if v, ok = vv[k]; !ok {
+				return nil, false
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(k)
+			if err != nil || i < 0 || i >= len(vv) {
+				return nil, false
+			}
+			v = vv[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
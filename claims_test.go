@@ -0,0 +1,70 @@
+package gothic
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/markbates/goth"
+)
+
+const claimsJSON = `{
+	"id": 1234567890123,
+	"login": "octocat",
+	"verified": true,
+	"a/b": {"m~n": "escaped"},
+	"profile": {"emails": [{"value": "first@example.com"}, {"value": "second@example.com"}]}
+}`
+
+func decodeClaims(t *testing.T) map[string]interface{} {
+	var claims map[string]interface{}
+	d := json.NewDecoder(strings.NewReader(claimsJSON))
+	d.UseNumber()
+	if err := d.Decode(&claims); err != nil {
+		t.Fatal(err)
+	}
+	return claims
+}
+
+func TestClaimString(t *testing.T) {
+	claims := decodeClaims(t)
+	tests := map[string]string{
+		"id":                      "1234567890123",
+		"login":                   "octocat",
+		"verified":                "true",
+		"/a~1b/m~0n":              "escaped",
+		"profile.emails.1.value":  "second@example.com",
+		"/profile/emails/0/value": "first@example.com",
+		"profile.emails.2.value":  "",
+		"profile.missing":         "",
+		"login.value":             "",
+		"":                        "",
+	}
+	for path, want := range tests {
+		if got := claimString(claims, path); got != want {
+			t.Errorf("%q: expected %q got %q", path, want, got)
+		}
+	}
+}
+
+func TestClaimMappingApply(t *testing.T) {
+	claims := decodeClaims(t)
+
+	var u goth.User
+	m := ClaimMapping{UserID: "id", NickName: "login", Email: "profile.emails.0.value"}
+	m.Apply(claims, &u)
+	if u.UserID != "1234567890123" || u.NickName != "octocat" || u.Email != "first@example.com" {
+		t.Errorf("unexpected user %+v", u)
+	}
+	if !reflect.DeepEqual(u.RawData, claims) {
+		t.Errorf("expected the whole response in RawData got %v", u.RawData)
+	}
+
+	m.RawData = map[string]string{"login": "login", "second": "/profile/emails/1/value", "missing": "missing"}
+	m.Apply(claims, &u)
+	want := map[string]interface{}{"login": "octocat", "second": "second@example.com"}
+	if !reflect.DeepEqual(u.RawData, want) {
+		t.Errorf("expected RawData %v got %v", want, u.RawData)
+	}
+}
@@ -0,0 +1,256 @@
+// Command gothic generates and inspects the keys and the flow cookies of
+// github.com/oov/gothic.
+//
+// Usage:
+//
+//	gothic keygen [-secret] [-encrypt bytes]
+//	gothic derive [secret]
+//	gothic decode [-keys file] [-name name] cookie
+//	gothic verify [-keys file] [-name name] cookie
+//
+// Keys are read from the GOTHIC_COOKIE_* environment variables unless -keys
+// names a key file of gothic.FileKeyProvider. cookie is either the value of
+// the flow cookie or a Cookie header, which may carry a chunked flow.
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/oov/gothic"
+)
+
+const usage = `usage: gothic <command> [arguments]
+
+commands:
+  keygen  generate keys for GOTHIC_COOKIE_AUTH and GOTHIC_COOKIE_ENCRYPT
+  derive  derive the keys of a GOTHIC_COOKIE_SECRET master secret
+  decode  decode and print a flow cookie
+  verify  report which of the rotation keys decode a flow cookie
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	var err error
+	switch cmd, args := os.Args[1], os.Args[2:]; cmd {
+	case "keygen":
+		err = keygen(os.Stdout, args)
+	case "derive":
+		err = derive(os.Stdout, os.Stdin, args)
+	case "decode":
+		err = decode(os.Stdout, args)
+	case "verify":
+		err = verify(os.Stdout, args)
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(os.Stdout, usage)
+	default:
+		fmt.Fprintf(os.Stderr, "gothic: unknown command %q\n\n%s", cmd, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gothic:", err)
+		os.Exit(1)
+	}
+}
+
+func keygen(w io.Writer, args []string) error {
+	fs := flag.NewFlagSet("keygen", flag.ContinueOnError)
+	secret := fs.Bool("secret", false, "generate a master secret for GOTHIC_COOKIE_SECRET instead")
+	encrypt := fs.Int("encrypt", 32, "size of the encryption key: 16, 24, 32, or 0 for none")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *secret {
+		fmt.Fprintf(w, "GOTHIC_COOKIE_SECRET=%s\n", encodeKey(securecookie.GenerateRandomKey(32)))
+		return nil
+	}
+	switch *encrypt {
+	case 0, 16, 24, 32:
+	default:
+		return fmt.Errorf("invalid encryption key size %d", *encrypt)
+	}
+	fmt.Fprintf(w, "GOTHIC_COOKIE_AUTH=%s\n", encodeKey(securecookie.GenerateRandomKey(64)))
+	if *encrypt > 0 {
+		fmt.Fprintf(w, "GOTHIC_COOKIE_ENCRYPT=%s\n", encodeKey(securecookie.GenerateRandomKey(*encrypt)))
+	}
+	return nil
+}
+
+func derive(w io.Writer, stdin io.Reader, args []string) error {
+	fs := flag.NewFlagSet("derive", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var s string
+	switch fs.NArg() {
+	case 0:
+		// reading the secret from stdin keeps it out of the shell history
+		line, err := bufio.NewReader(stdin).ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		s = strings.TrimRight(line, "\r\n")
+	case 1:
+		s = fs.Arg(0)
+	default:
+		return errors.New("derive takes at most one secret")
+	}
+	secret, err := gothic.ParseKey(s)
+	if err != nil {
+		return err
+	}
+	if len(secret) == 0 {
+		return errors.New("empty secret")
+	}
+
+	k := gothic.DeriveKeys(secret)
+	fmt.Fprintf(w, "GOTHIC_COOKIE_AUTH=%s\n", encodeKey(k.Auth))
+	fmt.Fprintf(w, "GOTHIC_COOKIE_ENCRYPT=%s\n", encodeKey(k.Encrypt))
+	fmt.Fprintf(w, "# fingerprint %s\n", k.Fingerprint())
+	return nil
+}
+
+func encodeKey(b []byte) string {
+	return "base64:" + base64.RawURLEncoding.EncodeToString(b)
+}
+
+// cookieFlags are the flags of the commands which read a flow cookie.
+type cookieFlags struct {
+	fs   *flag.FlagSet
+	keys *string
+	name *string
+}
+
+func newCookieFlags(name string) *cookieFlags {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	return &cookieFlags{
+		fs:   fs,
+		keys: fs.String("keys", "", "key file of gothic.FileKeyProvider instead of the environment"),
+		name: fs.String("name", gothic.CookieName, "name of the flow cookie"),
+	}
+}
+
+// parse returns the keys and a request carrying the cookie in args.
+func (f *cookieFlags) parse(args []string) ([]gothic.Keys, *http.Request, error) {
+	if err := f.fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+	if f.fs.NArg() != 1 {
+		return nil, nil, errors.New("a cookie is required")
+	}
+
+	var p gothic.KeyProvider = gothic.EnvKeyProvider{}
+	if *f.keys != "" {
+		p = &gothic.FileKeyProvider{Path: *f.keys}
+	}
+	keys, err := p.Keys()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(keys) == 0 {
+		return nil, nil, gothic.ErrNoKeys
+	}
+
+	cookie := strings.TrimPrefix(f.fs.Arg(0), "Cookie: ")
+	// values end with base64 padding, but never contain "=" elsewhere
+	if !strings.Contains(strings.TrimRight(cookie, "="), "=") {
+		cookie = *f.name + "=" + cookie
+	}
+	r, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	r.Header.Set("Cookie", cookie)
+	return keys, r, nil
+}
+
+func (f *cookieFlags) inspect(keys []gothic.Keys, r *http.Request) (*gothic.Flow, error) {
+	codecs, err := gothic.CodecsFromKeys(keys)
+	if err != nil {
+		return nil, err
+	}
+	g := &gothic.Gothic{CookieName: *f.name, Codecs: codecs}
+	return g.InspectFlow(r)
+}
+
+func decode(w io.Writer, args []string) error {
+	f := newCookieFlags("decode")
+	keys, r, err := f.parse(args)
+	if err != nil {
+		return err
+	}
+	flow, err := f.inspect(keys, r)
+	if err != nil {
+		return err
+	}
+
+	out := struct {
+		State    string            `json:"state"`
+		Provider string            `json:"provider"`
+		Tenant   string            `json:"tenant,omitempty"`
+		IssuedAt *time.Time        `json:"issued_at,omitempty"`
+		Age      string            `json:"age,omitempty"`
+		Legacy   bool              `json:"legacy,omitempty"`
+		Extras   map[string]string `json:"extras,omitempty"`
+		Session  json.RawMessage   `json:"session"`
+	}{
+		State:    flow.State,
+		Provider: flow.Provider,
+		Tenant:   flow.Tenant,
+		Legacy:   flow.Legacy,
+		Extras:   flow.Extras,
+	}
+	if !flow.IssuedAt.IsZero() {
+		out.IssuedAt = &flow.IssuedAt
+		out.Age = time.Since(flow.IssuedAt).Truncate(time.Second).String()
+	}
+	if json.Valid([]byte(flow.Session)) {
+		out.Session = json.RawMessage(flow.Session)
+	} else {
+		out.Session, _ = json.Marshal(flow.Session)
+	}
+
+	b, err := json.MarshalIndent(&out, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "%s\n", b)
+	return nil
+}
+
+func verify(w io.Writer, args []string) error {
+	f := newCookieFlags("verify")
+	keys, r, err := f.parse(args)
+	if err != nil {
+		return err
+	}
+
+	decoded := 0
+	for i, k := range keys {
+		if _, err := f.inspect([]gothic.Keys{k}, r); err != nil {
+			fmt.Fprintf(w, "key %d (%s): %v\n", i, k.Fingerprint(), err)
+			continue
+		}
+		decoded++
+		fmt.Fprintf(w, "key %d (%s): ok\n", i, k.Fingerprint())
+	}
+	if decoded == 0 {
+		return errors.New("no key decodes the cookie")
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/oov/gothic"
+)
+
+func TestKeygen(t *testing.T) {
+	var buf bytes.Buffer
+	if err := keygen(&buf, []string{"-encrypt", "24"}); err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		kv := strings.SplitN(line, "=", 2)
+		env[kv[0]] = kv[1]
+	}
+	for name, size := range map[string]int{"GOTHIC_COOKIE_AUTH": 64, "GOTHIC_COOKIE_ENCRYPT": 24} {
+		k, err := gothic.ParseKey(env[name])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(k) != size {
+			t.Errorf("%s: expected %d bytes got %d", name, size, len(k))
+		}
+	}
+
+	if err := keygen(&buf, []string{"-encrypt", "20"}); err == nil {
+		t.Error("expected an error for an invalid key size")
+	}
+}
+
+func TestDerive(t *testing.T) {
+	var buf bytes.Buffer
+	if err := derive(&buf, strings.NewReader("secret\n"), nil); err != nil {
+		t.Fatal(err)
+	}
+	var buf2 bytes.Buffer
+	if err := derive(&buf2, nil, []string{"secret"}); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != buf2.String() {
+		t.Errorf("expected the same keys got %q and %q", buf.String(), buf2.String())
+	}
+	if !strings.Contains(buf.String(), gothic.DeriveKeys([]byte("secret")).Fingerprint()) {
+		t.Errorf("expected the fingerprint got %q", buf.String())
+	}
+}
+
+// flowCookie returns a Cookie header of a flow started with keys.
+func flowCookie(t *testing.T, keys []gothic.Keys) string {
+	codecs, err := gothic.CodecsFromKeys(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := &gothic.Gothic{
+		Codecs: codecs,
+		Providers: gothic.NewRegistry(gothic.NewOAuth2Provider("example", gothic.OAuth2Config{
+			ClientID: "client",
+			AuthURL:  "https://example.com/authorize",
+		})),
+	}
+	w := httptest.NewRecorder()
+	if _, err = g.GetAuthURL("example", w, httptest.NewRequest("GET", "/", nil)); err != nil {
+		t.Fatal(err)
+	}
+	sc := w.Header().Get("Set-Cookie")
+	return sc[:strings.Index(sc, ";")]
+}
+
+func writeKeys(t *testing.T, secrets ...string) string {
+	var keys []string
+	for _, s := range secrets {
+		keys = append(keys, `{"secret":"`+s+`"}`)
+	}
+	file := filepath.Join(t.TempDir(), "keys.json")
+	if err := ioutil.WriteFile(file, []byte(`{"keys":[`+strings.Join(keys, ",")+`]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestDecode(t *testing.T) {
+	cookie := flowCookie(t, []gothic.Keys{gothic.DeriveKeys([]byte("a"))})
+	var buf bytes.Buffer
+	if err := decode(&buf, []string{"-keys", writeKeys(t, "a"), "Cookie: " + cookie}); err != nil {
+		t.Fatal(err)
+	}
+	var out struct {
+		State    string
+		Provider string
+		Age      string
+		Session  map[string]interface{}
+	}
+	if err := json.Unmarshal(buf.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Provider != "example" || out.State == "" || out.Age == "" {
+		t.Errorf("unexpected output %s", buf.String())
+	}
+	if _, ok := out.Session["AuthURL"]; !ok {
+		t.Errorf("expected the session JSON got %s", buf.String())
+	}
+
+	// the bare value is accepted too
+	value := cookie[strings.Index(cookie, "=")+1:]
+	if err := decode(&buf, []string{"-keys", writeKeys(t, "a"), value}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	cookie := flowCookie(t, []gothic.Keys{gothic.DeriveKeys([]byte("old"))})
+	var buf bytes.Buffer
+	if err := verify(&buf, []string{"-keys", writeKeys(t, "new", "old"), cookie}); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || strings.HasSuffix(lines[0], ": ok") || !strings.HasSuffix(lines[1], ": ok") {
+		t.Errorf("expected only the second key to decode got %q", buf.String())
+	}
+
+	if err := verify(&buf, []string{"-keys", writeKeys(t, "new"), cookie}); err == nil {
+		t.Error("expected an error when no key decodes")
+	}
+}
@@ -0,0 +1,232 @@
+package gothic
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/gorilla/securecookie"
+)
+
+// Codec encodes and decodes the value of the flow cookie.
+// name is the name of the cookie, which must be authenticated with the value.
+type Codec interface {
+	Encode(name, value string) (string, error)
+	Decode(name, encoded string) (string, error)
+}
+
+// SecureCookieCodec returns a Codec which encodes values with the first of
+// codecs and decodes them with any of them, as securecookie.EncodeMulti and
+// securecookie.DecodeMulti do.
+func SecureCookieCodec(codecs ...securecookie.Codec) Codec {
+	return secureCookieCodec(codecs)
+}
+
+type secureCookieCodec []securecookie.Codec
+
+func (c secureCookieCodec) Encode(name, value string) (string, error) {
+	return securecookie.EncodeMulti(name, value, c...)
+}
+
+func (c secureCookieCodec) Decode(name, encoded string) (string, error) {
+	var s string
+	err := securecookie.DecodeMulti(name, encoded, &s, c...)
+	return s, err
+}
+
+// errorCodec is a Codec which fails with err.
+type errorCodec struct {
+	err error
+}
+
+func (c errorCodec) Encode(name, value string) (string, error)   { return "", c.err }
+func (c errorCodec) Decode(name, encoded string) (string, error) { return "", c.err }
+
+// DefaultJOSEMaxAge is the lifetime of the tokens of JWTCodec and JWECodec
+// when their MaxAge is zero. It matches the default of securecookie.
+const DefaultJOSEMaxAge = 30 * 24 * time.Hour
+
+var (
+	errInvalidJOSE = errors.New("gothic: the value is not a valid token")
+	errExpiredJOSE = errors.New("gothic: the token has expired")
+)
+
+// joseClaims is the claim set of the tokens of JWTCodec and JWECodec.
+// Other services can read the flow from "val", see payload for its format.
+type joseClaims struct {
+	Name     string `json:"cookie"`
+	Value    string `json:"val"`
+	IssuedAt int64  `json:"iat"`
+	Expiry   int64  `json:"exp"`
+}
+
+func newJOSEClaims(name, value string, maxAge time.Duration) ([]byte, error) {
+	if maxAge == 0 {
+		maxAge = DefaultJOSEMaxAge
+	}
+	now := time.Now()
+	return json.Marshal(&joseClaims{
+		Name:     name,
+		Value:    value,
+		IssuedAt: now.Unix(),
+		Expiry:   now.Add(maxAge).Unix(),
+	})
+}
+
+func parseJOSEClaims(name string, b []byte) (string, error) {
+	var c joseClaims
+	if err := json.Unmarshal(b, &c); err != nil {
+		return "", errInvalidJOSE
+	}
+	if c.Name != name {
+		return "", errInvalidJOSE
+	}
+	if time.Now().Unix() >= c.Expiry {
+		return "", errExpiredJOSE
+	}
+	return c.Value, nil
+}
+
+// JWTCodec is a Codec which encodes values as HS256 signed JWTs, readable by
+// any JOSE library.
+type JWTCodec struct {
+	// Key is the HMAC key. It must be at least 32 bytes.
+	Key []byte
+	// MaxAge is the lifetime of the tokens. DefaultJOSEMaxAge is used when zero.
+	MaxAge time.Duration
+}
+
+var jwtHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+var errShortJWTKey = errors.New("gothic: JWTCodec requires a key of at least 32 bytes")
+
+// Encode returns value as a signed JWT.
+func (c *JWTCodec) Encode(name, value string) (string, error) {
+	if len(c.Key) < 32 {
+		return "", errShortJWTKey
+	}
+	claims, err := newJOSEClaims(name, value, c.MaxAge)
+	if err != nil {
+		return "", err
+	}
+	signingInput := jwtHeader + "." + base64.RawURLEncoding.EncodeToString(claims)
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(c.sign(signingInput)), nil
+}
+
+// Decode verifies the JWT and returns its value.
+func (c *JWTCodec) Decode(name, encoded string) (string, error) {
+	if len(c.Key) < 32 {
+		return "", errShortJWTKey
+	}
+	parts := strings.Split(encoded, ".")
+	if len(parts) != 3 {
+		return "", errInvalidJOSE
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	b, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(b, &header) != nil || header.Alg != "HS256" {
+		return "", errInvalidJOSE
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(sig, c.sign(parts[0]+"."+parts[1])) {
+		return "", errInvalidJOSE
+	}
+	claims, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", errInvalidJOSE
+	}
+	return parseJOSEClaims(name, claims)
+}
+
+func (c *JWTCodec) sign(signingInput string) []byte {
+	h := hmac.New(sha256.New, c.Key)
+	h.Write([]byte(signingInput))
+	return h.Sum(nil)
+}
+
+// JWECodec is a Codec which encodes values as JWEs encrypted with a shared
+// key ("alg":"dir", "enc":"A256GCM"), readable by any JOSE library.
+type JWECodec struct {
+	// Key is the AES-256 key. It must be 32 bytes.
+	Key []byte
+	// MaxAge is the lifetime of the tokens. DefaultJOSEMaxAge is used when zero.
+	MaxAge time.Duration
+}
+
+var jweHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"dir","enc":"A256GCM"}`))
+
+// Encode returns value encrypted as a compact JWE.
+func (c *JWECodec) Encode(name, value string) (string, error) {
+	aead, err := c.aead()
+	if err != nil {
+		return "", err
+	}
+	claims, err := newJOSEClaims(name, value, c.MaxAge)
+	if err != nil {
+		return "", err
+	}
+	iv := make([]byte, aead.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, iv); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nil, iv, claims, []byte(jweHeader))
+	ciphertext, tag := sealed[:len(sealed)-aead.Overhead()], sealed[len(sealed)-aead.Overhead():]
+	enc := base64.RawURLEncoding.EncodeToString
+	return jweHeader + ".." + enc(iv) + "." + enc(ciphertext) + "." + enc(tag), nil
+}
+
+// Decode decrypts the JWE and returns its value.
+func (c *JWECodec) Decode(name, encoded string) (string, error) {
+	aead, err := c.aead()
+	if err != nil {
+		return "", err
+	}
+	parts := strings.Split(encoded, ".")
+	if len(parts) != 5 || parts[1] != "" {
+		return "", errInvalidJOSE
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Enc string `json:"enc"`
+	}
+	b, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(b, &header) != nil || header.Alg != "dir" || header.Enc != "A256GCM" {
+		return "", errInvalidJOSE
+	}
+	var raw [3][]byte
+	for i := range raw {
+		if raw[i], err = base64.RawURLEncoding.DecodeString(parts[i+2]); err != nil {
+			return "", errInvalidJOSE
+		}
+	}
+	iv, ciphertext, tag := raw[0], raw[1], raw[2]
+	if len(iv) != aead.NonceSize() || len(tag) != aead.Overhead() {
+		return "", errInvalidJOSE
+	}
+	claims, err := aead.Open(nil, iv, append(ciphertext, tag...), []byte(parts[0]))
+	if err != nil {
+		return "", errInvalidJOSE
+	}
+	return parseJOSEClaims(name, claims)
+}
+
+func (c *JWECodec) aead() (cipher.AEAD, error) {
+	if len(c.Key) != 32 {
+		return nil, errors.New("gothic: JWECodec requires a 32 bytes key")
+	}
+	block, err := aes.NewCipher(c.Key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
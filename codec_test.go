@@ -0,0 +1,108 @@
+package gothic
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+)
+
+var joseKey = bytes.Repeat([]byte{0x42}, 32)
+
+func TestJOSECodec(t *testing.T) {
+	for _, c := range []Codec{&JWTCodec{Key: joseKey}, &JWECodec{Key: joseKey}} {
+		encoded, err := c.Encode(CookieName, "value")
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, err := c.Decode(CookieName, encoded)
+		if err != nil {
+			t.Fatalf("%T: %v", c, err)
+		}
+		if v != "value" {
+			t.Errorf("%T: expected %q got %q", c, "value", v)
+		}
+
+		if _, err = c.Decode("other", encoded); err == nil {
+			t.Errorf("%T: expected an error for another cookie name", c)
+		}
+		i := strings.LastIndexByte(encoded, '.') + 1
+		tampered := encoded[:i] + base64.RawURLEncoding.EncodeToString([]byte("tampered signature"))
+		if _, err = c.Decode(CookieName, tampered); err == nil {
+			t.Errorf("%T: expected an error for a tampered token", c)
+		}
+	}
+}
+
+func TestJWTCodecFormat(t *testing.T) {
+	encoded, err := (&JWTCodec{Key: joseKey}).Encode(CookieName, "value")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(encoded, ".")
+	if len(parts) != 3 {
+		t.Fatalf("expected a compact JWS got %q", encoded)
+	}
+	claims, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(claims), `"val":"value"`) {
+		t.Errorf("expected the value in the claims got %s", claims)
+	}
+}
+
+func TestJOSECodecExpired(t *testing.T) {
+	for _, c := range []Codec{&JWTCodec{Key: joseKey, MaxAge: -time.Second}, &JWECodec{Key: joseKey, MaxAge: -time.Second}} {
+		encoded, err := c.Encode(CookieName, "value")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = c.Decode(CookieName, encoded); err != errExpiredJOSE {
+			t.Errorf("%T: expected %v got %v", c, errExpiredJOSE, err)
+		}
+	}
+}
+
+func TestJOSECodecKeySize(t *testing.T) {
+	encoded, err := (&JWTCodec{Key: joseKey}).Encode(CookieName, "value")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// tokens signed with an empty key are forgeable by anyone
+	for _, key := range [][]byte{nil, joseKey[:16]} {
+		c := &JWTCodec{Key: key}
+		if _, err := c.Encode(CookieName, "value"); err != errShortJWTKey {
+			t.Errorf("%d bytes: expected %v got %v", len(key), errShortJWTKey, err)
+		}
+		if _, err := c.Decode(CookieName, encoded); err != errShortJWTKey {
+			t.Errorf("%d bytes: expected %v got %v", len(key), errShortJWTKey, err)
+		}
+	}
+	if _, err = (&JWECodec{Key: joseKey[:16]}).Encode(CookieName, "value"); err == nil {
+		t.Error("expected an error for a short key")
+	}
+}
+
+func TestCompleteAuthJOSECodec(t *testing.T) {
+	for _, c := range []Codec{&JWTCodec{Key: joseKey}, &JWECodec{Key: joseKey}} {
+		g := &Gothic{Codec: c}
+		cookie := gothicCookie(g, providerName)
+		w, r := wr("GET", "/?state="+lastState, nil)
+		r.Header.Set("Cookie", cookie)
+		user, err := g.CompleteAuth(providerName, w, r)
+		if err != nil {
+			t.Fatalf("%T: %v", c, err)
+		}
+		verifyUser(t, user)
+
+		// cookies of the default codec are rejected
+		cookie = beginAuthCookie()
+		w, r = wr("GET", "/?state="+lastState, nil)
+		r.Header.Set("Cookie", cookie)
+		if _, err = g.CompleteAuth(providerName, w, r); err == nil {
+			t.Errorf("%T: expected an error for a securecookie cookie", c)
+		}
+	}
+}
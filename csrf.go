@@ -0,0 +1,126 @@
+package gothic
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"html/template"
+	"net/http"
+
+	"github.com/gorilla/securecookie"
+)
+
+// CSRFProtection makes Gothic instances without CSRFProtection require flows
+// to be started by a POST request with a token issued by CSRFToken, so other
+// sites cannot log users in with an account of their choice.
+var CSRFProtection bool
+
+// CSRFFieldName is the name of the form field of the CSRF token.
+// The token may also be sent in the X-Gothic-CSRF header.
+const CSRFFieldName = "gothic_csrf"
+
+var (
+	// ErrMethodNotAllowed is returned by GetAuthURL when CSRF protection is
+	// enabled and the flow is not started by a POST request.
+	ErrMethodNotAllowed = errors.New("gothic: flows must be started by a POST request")
+	// ErrCSRFToken is returned by GetAuthURL when CSRF protection is enabled
+	// and the request has no valid CSRF token.
+	ErrCSRFToken = errors.New("gothic: CSRF token is missing or invalid")
+)
+
+func (g *Gothic) csrfProtection() bool {
+	return g.CSRFProtection || CSRFProtection
+}
+
+// csrfCookieName returns the name of the CSRF cookie with co. The __Host-
+// prefix keeps sibling domains from setting the cookie, which would pair it
+// with a token the attacker got from the site, but browsers only accept it
+// for Secure cookies of the host with Path=/.
+func (g *Gothic) csrfCookieName(co *Options) string {
+	name := g.cookieName() + "_csrf"
+	if co.Secure && co.Path == "/" && co.Domain == "" {
+		name = "__Host-" + name
+	}
+	return name
+}
+
+// CSRFToken returns the CSRF token of the package level configuration.
+func CSRFToken(w http.ResponseWriter, r *http.Request) (string, error) {
+	return defaultGothic.CSRFToken(w, r)
+}
+
+// CSRFField returns the CSRF token of the package level configuration as a
+// hidden form field.
+func CSRFField(w http.ResponseWriter, r *http.Request) (template.HTML, error) {
+	return defaultGothic.CSRFField(w, r)
+}
+
+// CSRFToken returns the token which starts a flow when CSRF protection is
+// enabled. The token is paired with a cookie set to w, so it must be called
+// before the response is written. The token of the cookie sent in r is
+// reused, so every form of a page gets the same token.
+//
+// Unless the cookie is Secure with Path=/ and no Domain, a sibling domain can
+// toss a cookie paired with a token of the attacker into the browser, since
+// the cookie is not tied to the browser it is issued to.
+func (g *Gothic) CSRFToken(w http.ResponseWriter, r *http.Request) (string, error) {
+	t, err := g.tenant(r)
+	if err != nil {
+		return "", err
+	}
+	c := loadConfig()
+	co := g.requestCookieOptions(c, t, r)
+	if token, ok := g.csrfCookieToken(c, &co, r); ok {
+		return token, nil
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(securecookie.GenerateRandomKey(24))
+	name := g.csrfCookieName(&co)
+	encoded, err := g.codec(c).Encode(name, token)
+	if err != nil {
+		return "", err
+	}
+	co.MaxAge = 0
+	http.SetCookie(w, cookie(name, encoded, &co))
+	return token, nil
+}
+
+// CSRFField returns the token of CSRFToken as a hidden form field to be put
+// in the login forms.
+func (g *Gothic) CSRFField(w http.ResponseWriter, r *http.Request) (template.HTML, error) {
+	token, err := g.CSRFToken(w, r)
+	if err != nil {
+		return "", err
+	}
+	return template.HTML(`<input type="hidden" name="` + CSRFFieldName + `" value="` + template.HTMLEscapeString(token) + `">`), nil
+}
+
+func (g *Gothic) csrfCookieToken(c *config, co *Options, r *http.Request) (string, bool) {
+	name := g.csrfCookieName(co)
+	ck, err := r.Cookie(name)
+	if err != nil {
+		return "", false
+	}
+	token, err := g.codec(c).Decode(name, ck.Value)
+	return token, err == nil && token != ""
+}
+
+// checkCSRF verifies that r is a POST request with the token of its cookie.
+func (g *Gothic) checkCSRF(c *config, t *Tenant, r *http.Request) error {
+	if r.Method != "POST" {
+		return ErrMethodNotAllowed
+	}
+	co := g.requestCookieOptions(c, t, r)
+	want, ok := g.csrfCookieToken(c, &co, r)
+	if !ok {
+		return ErrCSRFToken
+	}
+	got := r.Header.Get("X-Gothic-CSRF")
+	if got == "" {
+		got = r.PostFormValue(CSRFFieldName)
+	}
+	if subtle.ConstantTimeCompare([]byte(got), []byte(want)) != 1 {
+		return ErrCSRFToken
+	}
+	return nil
+}
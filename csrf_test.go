@@ -0,0 +1,122 @@
+package gothic
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func csrfRequest(g *Gothic, token, cookie string) (*http.Response, error) {
+	form := url.Values{CSRFFieldName: {token}}
+	w, r := wr("POST", "/auth/"+providerName, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if cookie != "" {
+		r.Header.Set("Cookie", cookie)
+	}
+	err := g.BeginAuth(providerName, w, r)
+	return w.Result(), err
+}
+
+func TestCSRFProtection(t *testing.T) {
+	g := &Gothic{CSRFProtection: true}
+
+	w, r := wr("GET", "/auth/"+providerName, nil)
+	if err := g.BeginAuth(providerName, w, r); err != ErrMethodNotAllowed {
+		t.Errorf("expected %v got %v", ErrMethodNotAllowed, err)
+	}
+
+	w, r = wr("GET", "/login", nil)
+	field, err := g.CSRFField(w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc := w.Header().Get("Set-Cookie")
+	cookie := sc[:strings.Index(sc, ";")]
+	token := string(field)
+	token = token[strings.Index(token, `value="`)+len(`value="`):]
+	token = token[:strings.Index(token, `"`)]
+
+	// the cookie is reused
+	w, r = wr("GET", "/login", nil)
+	r.Header.Set("Cookie", cookie)
+	if t2, err := g.CSRFToken(w, r); err != nil || t2 != token || w.Header().Get("Set-Cookie") != "" {
+		t.Errorf("expected the same token without a new cookie got %q %v", t2, err)
+	}
+
+	resp, err := csrfRequest(g, token, cookie)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSeeOther {
+		t.Errorf("expected %d got %d", http.StatusSeeOther, resp.StatusCode)
+	}
+
+	if _, err = csrfRequest(g, "forged", cookie); err != ErrCSRFToken {
+		t.Errorf("expected %v for a wrong token got %v", ErrCSRFToken, err)
+	}
+	if _, err = csrfRequest(g, token, ""); err != ErrCSRFToken {
+		t.Errorf("expected %v without the cookie got %v", ErrCSRFToken, err)
+	}
+	// a planted cookie is not authenticated
+	if _, err = csrfRequest(g, "planted", g.csrfCookieName(&CookieOptions)+"=planted"); err != ErrCSRFToken {
+		t.Errorf("expected %v for a planted cookie got %v", ErrCSRFToken, err)
+	}
+}
+
+func TestCSRFHostPrefix(t *testing.T) {
+	g := &Gothic{CSRFProtection: true}
+	w, r := wr("GET", "https://example.com/login", nil)
+	r.TLS = &tls.ConnectionState{}
+	token, err := g.CSRFToken(w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc := w.Header().Get("Set-Cookie")
+	if !strings.HasPrefix(sc, "__Host-"+g.cookieName()+"_csrf=") || !strings.Contains(sc, "Secure") {
+		t.Fatalf("expected a Secure __Host- cookie got %q", sc)
+	}
+
+	form := url.Values{CSRFFieldName: {token}}
+	w, r = wr("POST", "https://example.com/auth/"+providerName, strings.NewReader(form.Encode()))
+	r.TLS = &tls.ConnectionState{}
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("Cookie", sc[:strings.Index(sc, ";")])
+	if err := g.BeginAuth(providerName, w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	// a cookie without the prefix may be tossed by a sibling domain
+	w, r = wr("POST", "https://example.com/auth/"+providerName, strings.NewReader(form.Encode()))
+	r.TLS = &tls.ConnectionState{}
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("Cookie", strings.TrimPrefix(sc[:strings.Index(sc, ";")], "__Host-"))
+	if err := g.BeginAuth(providerName, w, r); err != ErrCSRFToken {
+		t.Errorf("expected %v got %v", ErrCSRFToken, err)
+	}
+}
+
+func TestHandlerCSRF(t *testing.T) {
+	h := newTestHandler(RestartFlow)
+	h.Gothic = &Gothic{CSRFProtection: true}
+
+	w, r := wr("GET", "/auth/"+providerName, nil)
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "POST" {
+		t.Errorf("expected %d got %d", http.StatusMethodNotAllowed, w.Code)
+	}
+
+	w, r = wr("POST", "/auth/"+providerName, nil)
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected %d got %d", http.StatusForbidden, w.Code)
+	}
+
+	// recovery offers a form instead of a redirect
+	w, r = wr("GET", "/auth/"+providerName+"/callback", nil)
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `name="`+CSRFFieldName+`"`) {
+		t.Errorf("expected a form with the token got %d %s", w.Code, w.Body.String())
+	}
+}
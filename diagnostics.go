@@ -0,0 +1,158 @@
+package gothic
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/markbates/goth"
+)
+
+// DiagnosticsHandler returns the diagnostics handler of the package level
+// configuration. See (*Gothic).DiagnosticsHandler.
+func DiagnosticsHandler(authorize func(r *http.Request) bool) http.Handler {
+	return defaultGothic.DiagnosticsHandler(authorize)
+}
+
+// DiagnosticsHandler returns a handler which serves the status of g as JSON:
+// the providers and their recent results, the fingerprints of the keys and
+// the cookie options. Keys, sessions and tokens are never exposed.
+//
+// Requests are served only when authorize returns true; a nil authorize
+// denies every request.
+func (g *Gothic) DiagnosticsHandler(authorize func(r *http.Request) bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if authorize == nil || !authorize(r) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(g.diagnostics())
+	})
+}
+
+type diagnostics struct {
+	Providers  []providerDiagnostics `json:"providers"`
+	Codec      string                `json:"codec"`
+	Encryption *bool                 `json:"encryption,omitempty"`
+	Keys       []keyDiagnostics      `json:"keys,omitempty"`
+	RandomKeys bool                  `json:"random_keys,omitempty"`
+	KeyError   string                `json:"key_error,omitempty"`
+	Cookie     cookieDiagnostics     `json:"cookie"`
+	Window     string                `json:"window"`
+}
+
+type providerDiagnostics struct {
+	Name        string     `json:"name"`
+	Type        string     `json:"type,omitempty"`
+	Protocol    string     `json:"protocol,omitempty"`
+	Success     int        `json:"success"`
+	Failure     int        `json:"failure"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+}
+
+type keyDiagnostics struct {
+	Fingerprint string `json:"fingerprint"`
+	Encrypted   bool   `json:"encrypted"`
+}
+
+type cookieDiagnostics struct {
+	Name     string `json:"name"`
+	Path     string `json:"path"`
+	Domain   string `json:"domain,omitempty"`
+	MaxAge   int    `json:"max_age"`
+	Secure   bool   `json:"secure"`
+	HttpOnly bool   `json:"http_only"`
+	MaxSize  int    `json:"max_size"`
+}
+
+func (g *Gothic) diagnostics() *diagnostics {
+	c := loadConfig()
+	co := g.cookieOptions(c, nil)
+	d := &diagnostics{
+		Cookie: cookieDiagnostics{
+			Name:     g.cookieName(),
+			Path:     co.Path,
+			Domain:   co.Domain,
+			MaxAge:   co.MaxAge,
+			Secure:   co.Secure,
+			HttpOnly: co.HttpOnly,
+			MaxSize:  g.maxCookieSize(),
+		},
+		Window: StatsWindow.String(),
+	}
+
+	encrypted := func(b bool) *bool { return &b }
+	switch g.Codec.(type) {
+	case nil:
+		d.Codec = "securecookie"
+		if len(g.Codecs) == 0 {
+			for _, k := range c.keys {
+				d.Keys = append(d.Keys, keyDiagnostics{Fingerprint: k.Fingerprint(), Encrypted: len(k.Encrypt) > 0})
+			}
+			if len(d.Keys) > 0 {
+				d.Encryption = encrypted(d.Keys[0].Encrypted)
+			}
+			d.RandomKeys = c.randomKeys
+			if c.err != nil {
+				d.KeyError = c.err.Error()
+			}
+		}
+	case *JWTCodec:
+		d.Codec = "jwt"
+		d.Encryption = encrypted(false)
+	case *JWECodec:
+		d.Codec = "jwe"
+		d.Encryption = encrypted(true)
+	default:
+		d.Codec = "custom"
+	}
+
+	providers := map[string]*providerDiagnostics{}
+	add := func(name string, p goth.Provider) {
+		pd := &providerDiagnostics{Name: name, Type: ProviderType(p), Protocol: "oauth2"}
+		if protocol(name, p) == OAuth1 {
+			pd.Protocol = "oauth1"
+		}
+		providers[name] = pd
+	}
+	for name, p := range goth.GetProviders() {
+		add(name, p)
+	}
+	if g.Providers != nil {
+		for _, name := range g.Providers.List() {
+			if p, err := g.Providers.Get(name); err == nil {
+				add(name, p)
+			}
+		}
+	}
+	// providers of tenants are only known by their stats
+	for _, st := range g.stats().Snapshot() {
+		st := st
+		pd := providers[st.Provider]
+		if pd == nil {
+			pd = &providerDiagnostics{Name: st.Provider}
+			providers[st.Provider] = pd
+		}
+		pd.Success, pd.Failure, pd.LastError = st.Success, st.Failure, st.LastError
+		if !st.LastSuccess.IsZero() {
+			pd.LastSuccess = &st.LastSuccess
+		}
+		if !st.LastErrorAt.IsZero() {
+			pd.LastErrorAt = &st.LastErrorAt
+		}
+	}
+
+	d.Providers = make([]providerDiagnostics, 0, len(providers))
+	for _, pd := range providers {
+		d.Providers = append(d.Providers, *pd)
+	}
+	sort.Slice(d.Providers, func(i, j int) bool { return d.Providers[i].Name < d.Providers[j].Name })
+	return d
+}
@@ -0,0 +1,80 @@
+package gothic
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	s := &Stats{}
+	s.Record("a", nil)
+	s.Record("a", errors.New(`Get "https://example.com/me?access_token=s3cret&x=1": timeout`))
+	s.Record("b", nil)
+
+	snap := s.Snapshot()
+	if len(snap) != 2 || snap[0].Provider != "a" || snap[1].Provider != "b" {
+		t.Fatalf("unexpected snapshot %+v", snap)
+	}
+	if snap[0].Success != 1 || snap[0].Failure != 1 || snap[0].LastSuccess.IsZero() {
+		t.Errorf("unexpected stats %+v", snap[0])
+	}
+	if strings.Contains(snap[0].LastError, "s3cret") || !strings.Contains(snap[0].LastError, "access_token=REDACTED&x=1") {
+		t.Errorf("expected the token to be redacted got %q", snap[0].LastError)
+	}
+}
+
+func TestDiagnosticsHandler(t *testing.T) {
+	g := &Gothic{Stats: &Stats{}}
+	cookie := gothicCookie(g, providerName)
+	w, r := wr("GET", "/?state="+lastState, nil)
+	r.Header.Set("Cookie", cookie)
+	if _, err := g.CompleteAuth(providerName, w, r); err != nil {
+		t.Fatal(err)
+	}
+	w, r = wr("GET", "/?state=wrong", nil)
+	r.Header.Set("Cookie", gothicCookie(g, providerName))
+	g.CompleteAuth(providerName, w, r)
+
+	w, r = wr("GET", "/debug/gothic", nil)
+	g.DiagnosticsHandler(nil).ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected %d got %d", http.StatusForbidden, w.Code)
+	}
+
+	w, r = wr("GET", "/debug/gothic", nil)
+	g.DiagnosticsHandler(func(*http.Request) bool { return true }).ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected %d got %d", http.StatusOK, w.Code)
+	}
+	body := w.Body.String()
+	if strings.Contains(body, userAccessToken) {
+		t.Errorf("expected no token got %s", body)
+	}
+
+	var d diagnostics
+	if err := json.Unmarshal(w.Body.Bytes(), &d); err != nil {
+		t.Fatal(err)
+	}
+	var found bool
+	for _, p := range d.Providers {
+		if p.Name != providerName {
+			continue
+		}
+		found = true
+		if p.Success != 1 || p.Failure != 1 || !strings.Contains(p.LastError, "state parameter does not match") {
+			t.Errorf("unexpected provider diagnostics %+v", p)
+		}
+	}
+	if !found {
+		t.Errorf("expected %s in %s", providerName, body)
+	}
+	if d.Codec != "securecookie" || len(d.Keys) != 1 || len(d.Keys[0].Fingerprint) != 16 {
+		t.Errorf("unexpected keys %s", body)
+	}
+	if d.Cookie.Name != CookieName || !d.Cookie.HttpOnly {
+		t.Errorf("unexpected cookie %+v", d.Cookie)
+	}
+}
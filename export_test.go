@@ -0,0 +1,4 @@
+package gothic
+
+// MinJWKSRefresh exposes minJWKSRefresh to the external tests.
+var MinJWKSRefresh = &minJWKSRefresh
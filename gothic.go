@@ -3,10 +3,12 @@ package gothic
 // this code is based on https://github.com/markbates/goth/blob/master/gothic/gothic.go
 
 import (
+	"crypto/sha256"
 	"encoding/base64"
 	"errors"
+	"fmt"
 	"net/http"
-	"os"
+	"net/url"
 	"time"
 
 	"github.com/gorilla/securecookie"
@@ -31,62 +33,194 @@ type Options struct {
 var CookieName = "_gothic"
 
 // CookieOptions is the options used to access the secure cookie.
+// Use Reload to change it while serving requests.
 var CookieOptions = Options{
 	Path:     "/",
 	HttpOnly: true,
 }
 
+// Protocol is the authorization protocol spoken by a provider.
+type Protocol int
+
+const (
+	// OAuth2 providers echo the state parameter back to the callback.
+	OAuth2 Protocol = iota
+	// OAuth1 providers echo the oauth_token request token back to the callback.
+	OAuth1
+
+	// stateUnsupported is the protocol of the providers listed in
+	// StateUnsupportedProvider. Only the request token of the ones speaking
+	// OAuth 1.0a is verified, since the others echo nothing back.
+	stateUnsupported Protocol = -1
+)
+
+// requestTokenExtra is the payload extra set when a provider listed in
+// StateUnsupportedProvider sent a request token to verify.
+const requestTokenExtra = "oauth_token"
+
+// ProtocolProvider is an optional interface implemented by a goth.Provider
+// to report the protocol it speaks.
+type ProtocolProvider interface {
+	Protocol() Protocol
+}
+
 // StateUnsupportedProvider is the list of OAuth2.0 state parameter unsupported provider.
+//
+// Deprecated: It is only consulted for providers which do not implement ProtocolProvider.
 var StateUnsupportedProvider = map[string]struct{}{
 	"twitter": struct{}{},
 	"lastfm":  struct{}{},
 }
 
-var codecs []securecookie.Codec
-
 const stateLen = 16
 
 func init() {
-	a := []byte(os.Getenv("GOTHIC_COOKIE_AUTH"))
-	if len(a) == 0 {
-		a = securecookie.GenerateRandomKey(64)
-	}
+	loadEnvKeys()
+}
 
-	e := []byte(os.Getenv("GOTHIC_COOKIE_ENCRYPT"))
-	if len(e) == 0 {
-		codecs = securecookie.CodecsFromPairs(a)
-	} else {
-		codecs = securecookie.CodecsFromPairs(a, e)
+// loadEnvKeys loads the keys of the environment, generating random keys when
+// none is set.
+func loadEnvKeys() {
+	err := LoadKeys(EnvKeyProvider{})
+	switch err {
+	case nil:
+	case ErrNoKeys:
+		keys := []Keys{{Auth: securecookie.GenerateRandomKey(64)}}
+		cs, _ := CodecsFromKeys(keys)
+		current.Store(&config{codecs: cs, keys: keys, randomKeys: true})
+	default:
+		// the error is reported by the flows and Validate instead of
+		// panicking, so programs such as cmd/gothic can diagnose the keys
+		current.Store(&config{err: err})
 	}
 }
 
+// Gothic holds the configuration of an authentication flow.
+//
+// The zero value is ready to use; zero fields fall back to the package level
+// configuration.
+type Gothic struct {
+	// Providers is consulted before goth.GetProvider.
+	Providers *Registry
+	// CookieName overrides CookieName when not empty.
+	CookieName string
+	// CookieOptions overrides CookieOptions when not nil.
+	CookieOptions *Options
+	// Codecs overrides the codecs built from the keys of EnvKeyProvider
+	// when not empty. See CodecsFromKeys.
+	Codecs []securecookie.Codec
+	// Codec encodes the flow cookie instead of Codecs when not nil.
+	// Set it to a JWTCodec or a JWECodec to share the flow with services
+	// which cannot read the securecookie format.
+	Codec Codec
+	// States overrides States when not nil.
+	States StateStore
+	// CompressPayload enables compression of the flow cookie along with
+	// the package level CompressPayload.
+	CompressPayload bool
+	// RejectLegacyPayload rejects flow cookies of the legacy format even
+	// when AcceptLegacyPayload is set.
+	RejectLegacyPayload bool
+	// MaxCookieSize overrides MaxCookieSize when not zero.
+	MaxCookieSize int
+	// Callbacks overrides Callbacks when not nil.
+	Callbacks *CallbackCache
+	// Stats overrides DefaultStats when not nil.
+	Stats *Stats
+	// CSRFProtection enables CSRF protection along with the package level
+	// CSRFProtection.
+	CSRFProtection bool
+	// TrustedProxies overrides TrustedProxies when its Networks are not nil.
+	TrustedProxies Proxies
+	// Binding binds flows to the browser which started them along with the
+	// package level FlowBinding.
+	Binding Binding
+	// RateLimiter overrides RateLimiter when not nil.
+	RateLimiter Limiter
+	// AccessPolicy overrides AccessPolicy when not nil.
+	AccessPolicy Policy
+	// Tenants resolves the tenant of each request when not nil.
+	// The providers and the cookie domain of the tenant are used instead of
+	// the ones above.
+	Tenants TenantResolver
+}
+
+var defaultGothic = &Gothic{}
+
 // BeginAuth is a convienence function for starting the authentication process.
 //
 // BeginAuth will redirect the user to the appropriate authentication end-point
 // for the requested provider.
 func BeginAuth(providerName string, w http.ResponseWriter, r *http.Request) error {
-	url, err := GetAuthURL(providerName, w, r)
+	return defaultGothic.BeginAuth(providerName, w, r)
+}
+
+// GetAuthURL starts the authentication process with the requested provided.
+// It will return a URL that should be used to send users to.
+//
+// I would recommend using the BeginAuth instead of doing all of these steps
+// yourself.
+func GetAuthURL(providerName string, w http.ResponseWriter, r *http.Request) (string, error) {
+	return defaultGothic.GetAuthURL(providerName, w, r)
+}
+
+// CompleteAuth completes the authentication process and fetches all of the
+// basic information about the user from the provider.
+func CompleteAuth(providerName string, w http.ResponseWriter, r *http.Request) (goth.User, error) {
+	return defaultGothic.CompleteAuth(providerName, w, r)
+}
+
+// BeginAuth is a convienence function for starting the authentication process.
+//
+// BeginAuth will redirect the user to the appropriate authentication end-point
+// for the requested provider.
+func (g *Gothic) BeginAuth(providerName string, w http.ResponseWriter, r *http.Request) error {
+	url, err := g.GetAuthURL(providerName, w, r)
 	if err != nil {
 		return err
 	}
 
-	http.Redirect(w, r, url, http.StatusTemporaryRedirect)
+	code := http.StatusTemporaryRedirect
+	if r.Method == "POST" {
+		// 307 would make the browser POST to the provider
+		code = http.StatusSeeOther
+	}
+	http.Redirect(w, r, url, code)
 	return nil
 }
 
 // GetAuthURL starts the authentication process with the requested provided.
 // It will return a URL that should be used to send users to.
-//
-// I would recommend using the BeginAuth instead of doing all of these steps
-// yourself.
-func GetAuthURL(providerName string, w http.ResponseWriter, r *http.Request) (string, error) {
-	provider, err := goth.GetProvider(providerName)
+func (g *Gothic) GetAuthURL(providerName string, w http.ResponseWriter, r *http.Request) (string, error) {
+	t, err := g.tenant(r)
 	if err != nil {
 		return "", err
 	}
 
+	provider, err := g.provider(t, providerName)
+	if err != nil {
+		return "", err
+	}
+	if l := g.rateLimiter(); l != nil {
+		if ok, d := l.Allow(g.limitKey("begin", r, providerName)); !ok {
+			return "", &RateLimitError{RetryAfter: d}
+		}
+	}
+	c := loadConfig()
+
+	if g.csrfProtection() {
+		if err = g.checkCSRF(c, t, r); err != nil {
+			return "", err
+		}
+	}
+
 	state := base64.URLEncoding.EncodeToString(securecookie.GenerateRandomKey(stateLen * 3 / 4))
-	sess, err := provider.BeginAuth(state)
+	var sess goth.Session
+	if cp, ok := provider.(CallbackProvider); ok {
+		sess, err = cp.BeginAuthWithCallback(state, g.callbackURL(r, t, providerName))
+	} else {
+		sess, err = provider.BeginAuth(state)
+	}
 	if err != nil {
 		return "", err
 	}
@@ -96,56 +230,341 @@ func GetAuthURL(providerName string, w http.ResponseWriter, r *http.Request) (st
 		return "", err
 	}
 
-	encoded, err := securecookie.EncodeMulti(CookieName, state+sess.Marshal(), codecs...)
+	var verifyToken bool
+	switch protocol(providerName, provider) {
+	case OAuth1:
+		state, err = requestTokenState(url)
+		if err != nil {
+			return "", err
+		}
+	case stateUnsupported:
+		if s, err := requestTokenState(url); err == nil {
+			state, verifyToken = s, true
+		}
+	}
+
+	p := &payload{
+		State:    state,
+		Provider: providerName,
+		IssuedAt: time.Now().Unix(),
+		Session:  sess.Marshal(),
+	}
+	if t != nil {
+		p.Tenant = t.ID
+	}
+	if verifyToken {
+		p.Extras = map[string]string{requestTokenExtra: "1"}
+	}
+	co := g.requestCookieOptions(c, t, r)
+	g.bind(p, w, r, co)
+	value, err := p.encode(g.CompressPayload || CompressPayload)
+	if err != nil {
+		return "", err
+	}
+
+	name := g.cookieName()
+	encoded, err := g.codec(c).Encode(name, value)
 	if err != nil {
 		return "", err
 	}
 
-	http.SetCookie(w, cookie(CookieName, encoded, &CookieOptions))
+	err = setChunkedCookie(w, r, name, encoded, &co, g.maxCookieSize())
+	if err != nil {
+		return "", err
+	}
 
-	return url, err
+	return url, nil
 }
 
 // CompleteAuth completes the authentication process and fetches all of the
 // basic information about the user from the provider.
-func CompleteAuth(providerName string, w http.ResponseWriter, r *http.Request) (goth.User, error) {
-	provider, err := goth.GetProvider(providerName)
+//
+// A *FlowCookieError is returned when the flow cookie is missing or does not
+// decode, which happens when the callback is not preceded by BeginAuth in the
+// same browser, a *BindingError when the flow is bound to another browser,
+// a *RateLimitError when the client failed too many callbacks, and an
+// *AccessDeniedError when the access policy does not admit the user.
+func (g *Gothic) CompleteAuth(providerName string, w http.ResponseWriter, r *http.Request) (user goth.User, err error) {
+	t, err := g.tenant(r)
 	if err != nil {
 		return goth.User{}, err
 	}
 
-	c, err := r.Cookie(CookieName)
+	provider, err := g.provider(t, providerName)
 	if err != nil {
 		return goth.User{}, err
 	}
+	if l := g.rateLimiter(); l != nil {
+		key := g.limitKey("callback", r, providerName)
+		if d := l.Delay(key); d > 0 {
+			return goth.User{}, &RateLimitError{RetryAfter: d}
+		}
+		defer func() {
+			if err != nil {
+				l.Allow(key)
+			}
+		}()
+	}
+	// only known providers are recorded to bound the memory
+	defer func() { g.stats().Record(providerName, err) }()
+	c := loadConfig()
 
-	var ss string
-	err = securecookie.DecodeMulti(CookieName, c.Value, &ss, codecs...)
-	if err != nil {
+	callbacks := g.callbacks()
+	var callbackKey string
+	if callbacks != nil {
+		callbackKey = g.callbackKey(r, t, providerName, provider)
+	}
+
+	name := g.cookieName()
+	encoded, err := readChunkedCookie(r, name, g.maxCookieSize())
+	if err == ErrCookieTooLarge {
 		return goth.User{}, err
 	}
+	// a duplicate callback may arrive after the first one deleted the cookie
+	if err == http.ErrNoCookie && callbacks != nil {
+		if e := callbacks.lookup(callbackKey); e != nil {
+			if err = g.verifyBinding(e.flow, r); err != nil {
+				return goth.User{}, err
+			}
+			if user, err = callbacks.wait(e); err == nil && !e.flow.bound(BindCookie) {
+				return goth.User{}, ErrCallbackCompleted
+			}
+			return user, err
+		}
+	}
+	if err != nil {
+		return goth.User{}, &FlowCookieError{Missing: err == http.ErrNoCookie, Err: err}
+	}
 
-	co := CookieOptions
-	co.MaxAge = -1
-	http.SetCookie(w, cookie(CookieName, "", &co))
+	p, err := g.decode(c, name, encoded)
+	if err == nil && p.legacy && !g.acceptLegacyPayload() {
+		err = ErrLegacyPayload
+	}
+	if err != nil {
+		return goth.User{}, &FlowCookieError{Err: err}
+	}
+
+	co := g.requestCookieOptions(c, t, r)
+	deleteChunkedCookie(w, r, name, &co)
+
+	if !p.legacy {
+		if p.Provider != providerName {
+			return goth.User{}, errors.New("gothic: flow was started with another provider")
+		}
+		var tenantID string
+		if t != nil {
+			tenantID = t.ID
+		}
+		if p.Tenant != tenantID {
+			return goth.User{}, errors.New("gothic: flow was started on another tenant")
+		}
+	}
 
 	// verify state
-	_, stateUnsupported := StateUnsupportedProvider[providerName]
-	if len(ss) < stateLen || (!stateUnsupported && r.URL.Query().Get("state") != ss[:stateLen]) {
-		return goth.User{}, errors.New("oauth 2.0 state parameter does not match")
+	switch protocol(providerName, provider) {
+	case OAuth1:
+		if hashState(r.URL.Query().Get("oauth_token")) != p.State {
+			return goth.User{}, errors.New("oauth 1.0a request token does not match")
+		}
+	case stateUnsupported:
+		if _, ok := p.Extras[requestTokenExtra]; ok && hashState(r.URL.Query().Get("oauth_token")) != p.State {
+			return goth.User{}, errors.New("oauth 1.0a request token does not match")
+		}
+	default:
+		if r.URL.Query().Get("state") != p.State {
+			return goth.User{}, errors.New("oauth 2.0 state parameter does not match")
+		}
+	}
+
+	if err = g.verifyBinding(p, r); err != nil {
+		return goth.User{}, err
+	}
+
+	if callbacks != nil {
+		e, first := callbacks.start(callbackKey, p)
+		if !first {
+			return callbacks.wait(e)
+		}
+		defer func() { callbacks.finish(e, user, err) }()
 	}
 
-	sess, err := provider.UnmarshalSession(ss[stateLen:])
+	if states := g.states(); states != nil {
+		err = states.Consume(p.State, time.Now().Add(StateLifetime))
+		if err != nil {
+			return goth.User{}, err
+		}
+	}
+
+	sess, err := provider.UnmarshalSession(p.Session)
 	if err != nil {
 		return goth.User{}, err
 	}
 
-	_, err = sess.Authorize(provider, r.URL.Query())
+	_, err = sess.Authorize(unalias(provider), r.URL.Query())
 	if err != nil {
 		return goth.User{}, err
 	}
 
-	return provider.FetchUser(sess)
+	user, err = provider.FetchUser(sess)
+	if err != nil {
+		return goth.User{}, err
+	}
+
+	if policy := g.accessPolicy(); policy != nil {
+		if err = policy.Check(user); err != nil {
+			return goth.User{}, err
+		}
+	}
+	return user, nil
+}
+
+// FlowCookieError is returned by CompleteAuth when the flow cookie is missing
+// or does not decode.
+type FlowCookieError struct {
+	// Missing is set when the browser did not send the cookie at all.
+	Missing bool
+	Err     error
+}
+
+func (e *FlowCookieError) Error() string { return e.Err.Error() }
+
+// Unwrap returns the underlying error.
+func (e *FlowCookieError) Unwrap() error { return e.Err }
+
+// decode decodes the flow cookie encoded in any format.
+func (g *Gothic) decode(c *config, name, encoded string) (*payload, error) {
+	s, err := g.codec(c).Decode(name, encoded)
+	if err != nil {
+		return nil, err
+	}
+	return decodePayload(s)
+}
+
+func (g *Gothic) tenant(r *http.Request) (*Tenant, error) {
+	if g.Tenants == nil {
+		return nil, nil
+	}
+	return g.Tenants(r)
+}
+
+func (g *Gothic) provider(t *Tenant, name string) (goth.Provider, error) {
+	if t != nil {
+		if t.Providers == nil {
+			return nil, fmt.Errorf("no provider for %s exists", name)
+		}
+		return t.Providers.Get(name)
+	}
+	if g.Providers != nil {
+		if p, err := g.Providers.Get(name); err == nil {
+			return p, nil
+		}
+	}
+	return goth.GetProvider(name)
+}
+
+func (g *Gothic) cookieName() string {
+	if g.CookieName != "" {
+		return g.CookieName
+	}
+	return CookieName
+}
+
+func (g *Gothic) cookieOptions(c *config, t *Tenant) Options {
+	co := c.cookieOptions()
+	if g.CookieOptions != nil {
+		co = *g.CookieOptions
+	}
+	if t != nil && t.CookieDomain != "" {
+		co.Domain = t.CookieDomain
+	}
+	return co
+}
+
+func (g *Gothic) codec(c *config) Codec {
+	if g.Codec != nil {
+		return g.Codec
+	}
+	if len(g.Codecs) > 0 {
+		return SecureCookieCodec(g.Codecs...)
+	}
+	if c.err != nil {
+		return errorCodec{c.err}
+	}
+	return SecureCookieCodec(c.codecs...)
+}
+
+func (g *Gothic) maxCookieSize() int {
+	if g.MaxCookieSize != 0 {
+		return g.MaxCookieSize
+	}
+	return MaxCookieSize
+}
+
+func (g *Gothic) callbacks() *CallbackCache {
+	if g.Callbacks != nil {
+		return g.Callbacks
+	}
+	return Callbacks
+}
+
+// callbackKey identifies the callback r for the CallbackCache.
+func (g *Gothic) callbackKey(r *http.Request, t *Tenant, providerName string, provider goth.Provider) string {
+	state := r.URL.Query().Get("state")
+	switch protocol(providerName, provider) {
+	case OAuth1:
+		state = hashState(r.URL.Query().Get("oauth_token"))
+	case stateUnsupported:
+		// the callback carries whatever the provider echoes back
+		state = hashState(r.URL.RawQuery)
+	}
+	var tenantID string
+	if t != nil {
+		tenantID = t.ID
+	}
+	return g.clientKey(r, providerName) + tenantID + "\x00" + state
+}
+
+func (g *Gothic) stats() *Stats {
+	if g.Stats != nil {
+		return g.Stats
+	}
+	return DefaultStats
+}
+
+func (g *Gothic) states() StateStore {
+	if g.States != nil {
+		return g.States
+	}
+	return States
+}
+
+func protocol(providerName string, provider goth.Provider) Protocol {
+	if p, ok := provider.(ProtocolProvider); ok {
+		return p.Protocol()
+	}
+	if _, ok := StateUnsupportedProvider[providerName]; ok {
+		return stateUnsupported
+	}
+	return OAuth2
+}
+
+// requestTokenState derives the state of an OAuth 1.0a flow from the request
+// token in its authorization URL, since OAuth 1.0a has no state parameter.
+func requestTokenState(authURL string) (string, error) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		return "", err
+	}
+	token := u.Query().Get("oauth_token")
+	if token == "" {
+		return "", errors.New("oauth 1.0a authorization URL has no oauth_token")
+	}
+	return hashState(token), nil
+}
+
+func hashState(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return base64.URLEncoding.EncodeToString(h[:])[:stateLen]
 }
 
 func cookie(name, value string, opt *Options) *http.Cookie {
@@ -11,7 +11,7 @@ import (
 )
 
 func init() {
-	goth.UseProviders(&mockProvider{})
+	goth.UseProviders(&mockProvider{}, &mockOAuth1Provider{})
 }
 
 func wr(method, url string, body io.Reader) (*httptest.ResponseRecorder, *http.Request) {
@@ -23,8 +23,16 @@ func wr(method, url string, body io.Reader) (*httptest.ResponseRecorder, *http.R
 }
 
 func beginAuthCookie() string {
+	return beginAuthCookieWith(providerName)
+}
+
+func beginAuthCookieWith(providerName string) string {
+	return gothicCookie(defaultGothic, providerName)
+}
+
+func gothicCookie(g *Gothic, providerName string) string {
 	w, r := wr("GET", "/", nil)
-	err := BeginAuth(providerName, w, r)
+	err := g.BeginAuth(providerName, w, r)
 	if err != nil {
 		panic(err)
 	}
@@ -33,6 +41,10 @@ func beginAuthCookie() string {
 }
 
 func verifyUser(t *testing.T, user goth.User) {
+	verifyUserWith(t, providerName, user)
+}
+
+func verifyUserWith(t *testing.T, providerName string, user goth.User) {
 	if user.Provider != providerName {
 		t.Errorf("expected user.Provider value %q got %q", providerName, user.Provider)
 	}
@@ -130,14 +142,86 @@ func TestCompleteAuthStateMismatch(t *testing.T) {
 	}
 }
 
-func TestCompleteAuthSkipStateCheck(t *testing.T) {
-	StateUnsupportedProvider[providerName] = struct{}{}
-	w, r := wr("GET", "/", nil)
-	r.Header.Set("Cookie", beginAuthCookie())
-	user, err := CompleteAuth(providerName, w, r)
+func TestCompleteAuthOAuth1(t *testing.T) {
+	w, r := wr("GET", "/?oauth_token="+requestToken, nil)
+	r.Header.Set("Cookie", beginAuthCookieWith(oauth1Name))
+	user, err := CompleteAuth(oauth1Name, w, r)
 	if err != nil {
 		t.Fatal(err)
 	}
-	verifyUser(t, user)
-	delete(StateUnsupportedProvider, providerName)
+	verifyUserWith(t, oauth1Name, user)
+}
+
+func TestCompleteAuthOAuth1TokenMismatch(t *testing.T) {
+	for _, q := range []string{"", "?oauth_token=other", "?state=" + lastState} {
+		w, r := wr("GET", "/"+q, nil)
+		r.Header.Set("Cookie", beginAuthCookieWith(oauth1Name))
+		_, err := CompleteAuth(oauth1Name, w, r)
+		if err == nil {
+			t.Fatalf("%q: expected error got none", q)
+		}
+		msg := "oauth 1.0a request token does not match"
+		if err.Error() != msg {
+			t.Fatalf("%q: expected %q got %q", q, msg, err)
+		}
+	}
+}
+
+// listedProvider is a provider of StateUnsupportedProvider which does not
+// implement ProtocolProvider.
+type listedProvider struct {
+	namedProvider
+	authURL string
+}
+
+func (p *listedProvider) BeginAuth(state string) (goth.Session, error) {
+	s, err := p.mockProvider.BeginAuth(state)
+	s.(*mockSession).AuthURL = p.authURL
+	return s, err
+}
+
+func TestCompleteAuthStateUnsupported(t *testing.T) {
+	lastfm := &listedProvider{namedProvider{name: "lastfm"}, "https://www.last.fm/api/auth?api_key=key&cb=http%3A%2F%2Fexample.com%2Fcallback"}
+	twitter := &listedProvider{namedProvider{name: "twitter"}, "https://api.twitter.com/oauth/authenticate?oauth_token=" + requestToken}
+	g := &Gothic{Providers: NewRegistry(lastfm, twitter)}
+	complete := func(name, query string) error {
+		w, r := wr("GET", "/auth/"+name, nil)
+		if err := g.BeginAuth(name, w, r); err != nil {
+			return err
+		}
+		sc := w.Header().Get("Set-Cookie")
+		w, r = wr("GET", "/auth/"+name+"/callback?"+query, nil)
+		r.Header.Set("Cookie", sc[:strings.Index(sc, ";")])
+		_, err := g.CompleteAuth(name, w, r)
+		return err
+	}
+
+	// lastfm echoes its own token back instead of a state
+	if err := complete("lastfm", "token=t0ken"); err != nil {
+		t.Errorf("lastfm: expected no error got %v", err)
+	}
+	if err := complete("twitter", "oauth_token="+requestToken+"&oauth_verifier=v"); err != nil {
+		t.Errorf("twitter: expected no error got %v", err)
+	}
+	if err := complete("twitter", "oauth_token=other&oauth_verifier=v"); err == nil || err.Error() != "oauth 1.0a request token does not match" {
+		t.Errorf("twitter: expected the request token to be verified got %v", err)
+	}
+}
+
+func TestProtocol(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider goth.Provider
+		protocol Protocol
+	}{
+		{providerName, &mockProvider{}, OAuth2},
+		{oauth1Name, &mockOAuth1Provider{}, OAuth1},
+		{"twitter", &mockProvider{}, stateUnsupported},
+		{"lastfm", &mockProvider{}, stateUnsupported},
+	}
+	for _, tt := range tests {
+		if p := protocol(tt.name, tt.provider); p != tt.protocol {
+			t.Errorf("%s: expected protocol %d got %d", tt.name, tt.protocol, p)
+		}
+	}
 }
@@ -0,0 +1,215 @@
+package gothictest
+
+import (
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/markbates/goth"
+	"github.com/oov/gothic"
+)
+
+// NewProviderFunc returns the goth.Provider under test, configured to
+// authenticate users against s and to redirect them back to callbackURL.
+type NewProviderFunc func(s *Server, callbackURL string) goth.Provider
+
+// ConformanceUser is the user the Server used by CheckProvider authenticates.
+var ConformanceUser = User{
+	ID:            "conformance",
+	Email:         "conformance@example.com",
+	EmailVerified: true,
+	Name:          "Conformance User",
+	NickName:      "conformance",
+}
+
+const conformanceState = "c0nf0rmanceSt4te"
+
+// TestProvider runs CheckProvider and reports each violated expectation as a
+// test error. It is intended to be called from a provider's own tests.
+func TestProvider(t *testing.T, newProvider NewProviderFunc) {
+	for _, err := range CheckProvider(newProvider) {
+		t.Error(err)
+	}
+}
+
+// CheckProvider drives the provider returned by newProvider through gothic
+// against a local Server and returns every expectation it violates:
+//
+//   - the auth URL returned by the session contains the state
+//   - sessions survive a Marshal / UnmarshalSession round trip
+//   - UnmarshalSession rejects malformed data
+//   - FetchUser fails on a session which has not been authorized
+//   - Authorize can be called again on an authorized session
+//   - a whole flow completes through GetAuthURL and CompleteAuth of a Gothic
+func CheckProvider(newProvider NewProviderFunc) []error {
+	s := NewServer()
+	defer s.Close()
+	s.AddUser(ConformanceUser)
+
+	c := &conformance{server: s, newProvider: newProvider}
+	c.check("state", c.checkState)
+	c.check("round trip", c.checkRoundTrip)
+	c.check("malformed session", c.checkMalformedSession)
+	c.check("fetch without token", c.checkFetchWithoutToken)
+	c.check("authorize twice", c.checkAuthorizeTwice)
+	c.check("flow", c.checkFlow)
+	return c.errs
+}
+
+type conformance struct {
+	server      *Server
+	newProvider NewProviderFunc
+	errs        []error
+}
+
+func (c *conformance) check(name string, f func(p goth.Provider) error) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.errs = append(c.errs, fmt.Errorf("%s: panic: %v", name, r))
+		}
+	}()
+	if err := f(c.newProvider(c.server, "http://conformance.example.com/callback")); err != nil {
+		c.errs = append(c.errs, fmt.Errorf("%s: %v", name, err))
+	}
+}
+
+func (c *conformance) checkState(p goth.Provider) error {
+	sess, err := p.BeginAuth(conformanceState)
+	if err != nil {
+		return err
+	}
+	authURL, err := sess.GetAuthURL()
+	if err != nil {
+		return err
+	}
+	u, err := url.Parse(authURL)
+	if err != nil {
+		return err
+	}
+	if state := u.Query().Get("state"); state != conformanceState {
+		return fmt.Errorf("expected state %q in auth URL got %q", conformanceState, state)
+	}
+	return nil
+}
+
+func (c *conformance) checkRoundTrip(p goth.Provider) error {
+	sess, err := p.BeginAuth(conformanceState)
+	if err != nil {
+		return err
+	}
+	if err = roundTrip(p, sess); err != nil {
+		return fmt.Errorf("before authorization: %v", err)
+	}
+
+	params, err := c.authorize(sess)
+	if err != nil {
+		return err
+	}
+	if _, err = sess.Authorize(p, params); err != nil {
+		return err
+	}
+	if err = roundTrip(p, sess); err != nil {
+		return fmt.Errorf("after authorization: %v", err)
+	}
+	return nil
+}
+
+func (c *conformance) checkMalformedSession(p goth.Provider) error {
+	if _, err := p.UnmarshalSession("}not a session{"); err == nil {
+		return errors.New("expected error got none")
+	}
+	return nil
+}
+
+func (c *conformance) checkFetchWithoutToken(p goth.Provider) error {
+	sess, err := p.BeginAuth(conformanceState)
+	if err != nil {
+		return err
+	}
+	user, err := p.FetchUser(sess)
+	if err == nil {
+		return fmt.Errorf("expected error got user %q", user.UserID)
+	}
+	return nil
+}
+
+func (c *conformance) checkAuthorizeTwice(p goth.Provider) error {
+	sess, err := p.BeginAuth(conformanceState)
+	if err != nil {
+		return err
+	}
+	params, err := c.authorize(sess)
+	if err != nil {
+		return err
+	}
+	first, err := sess.Authorize(p, params)
+	if err != nil {
+		return err
+	}
+	second, err := sess.Authorize(p, params)
+	if err != nil {
+		return fmt.Errorf("second call: %v", err)
+	}
+	if first != second {
+		return fmt.Errorf("expected the same token %q got %q", first, second)
+	}
+	return nil
+}
+
+func (c *conformance) checkFlow(p goth.Provider) error {
+	g := &gothic.Gothic{Providers: gothic.NewRegistry(p)}
+	w, r := httptest.NewRecorder(), httptest.NewRequest("GET", "/auth/"+p.Name(), nil)
+	authURL, err := g.GetAuthURL(p.Name(), w, r)
+	if err != nil {
+		return err
+	}
+	callback, err := c.server.Authorize(authURL)
+	if err != nil {
+		return err
+	}
+	r = httptest.NewRequest("GET", callback.String(), nil)
+	for _, cookie := range w.Result().Cookies() {
+		r.AddCookie(cookie)
+	}
+	user, err := g.CompleteAuth(p.Name(), httptest.NewRecorder(), r)
+	if err != nil {
+		return err
+	}
+
+	if user.Provider != p.Name() {
+		return fmt.Errorf("expected user.Provider value %q got %q", p.Name(), user.Provider)
+	}
+	if user.UserID != ConformanceUser.ID {
+		return fmt.Errorf("expected user.UserID value %q got %q", ConformanceUser.ID, user.UserID)
+	}
+	if user.AccessToken == "" {
+		return errors.New("expected user.AccessToken got none")
+	}
+	return nil
+}
+
+func (c *conformance) authorize(sess goth.Session) (url.Values, error) {
+	authURL, err := sess.GetAuthURL()
+	if err != nil {
+		return nil, err
+	}
+	callback, err := c.server.Authorize(authURL)
+	if err != nil {
+		return nil, err
+	}
+	return callback.Query(), nil
+}
+
+func roundTrip(p goth.Provider, sess goth.Session) error {
+	data := sess.Marshal()
+	sess2, err := p.UnmarshalSession(data)
+	if err != nil {
+		return err
+	}
+	if data2 := sess2.Marshal(); data2 != data {
+		return fmt.Errorf("expected %q got %q", data, data2)
+	}
+	return nil
+}
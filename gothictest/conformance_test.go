@@ -0,0 +1,69 @@
+package gothictest_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/markbates/goth"
+	"github.com/oov/gothic/gothictest"
+)
+
+func TestProviderConformance(t *testing.T) {
+	gothictest.TestProvider(t, func(s *gothictest.Server, callbackURL string) goth.Provider {
+		return s.Provider("conformance", callbackURL)
+	})
+}
+
+type brokenProvider struct{}
+
+func (p *brokenProvider) Name() string { return "broken" }
+
+func (p *brokenProvider) Debug(debug bool) {}
+
+func (p *brokenProvider) BeginAuth(state string) (goth.Session, error) {
+	return &brokenSession{}, nil
+}
+
+func (p *brokenProvider) UnmarshalSession(data string) (goth.Session, error) {
+	return &brokenSession{}, nil
+}
+
+func (p *brokenProvider) FetchUser(s goth.Session) (goth.User, error) {
+	return goth.User{Provider: p.Name()}, nil
+}
+
+type brokenSession struct{}
+
+func (s *brokenSession) GetAuthURL() (string, error) {
+	return "http://broken.example.com/authorize", nil
+}
+
+func (s *brokenSession) Marshal() string {
+	return "{}"
+}
+
+func (s *brokenSession) Authorize(p goth.Provider, params goth.Params) (string, error) {
+	panic("not implemented")
+}
+
+func TestCheckProvider(t *testing.T) {
+	errs := gothictest.CheckProvider(func(s *gothictest.Server, callbackURL string) goth.Provider {
+		return &brokenProvider{}
+	})
+
+	var msgs []string
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+	got := strings.Join(msgs, "\n")
+	for _, want := range []string{
+		"state: expected state",
+		"malformed session: expected error got none",
+		"fetch without token: expected error",
+		"flow: gothictest: \"http://broken.example.com/authorize\" is not the authorization endpoint",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected %q in violations got:\n%s", want, got)
+		}
+	}
+}
@@ -0,0 +1,9 @@
+package gothictest
+
+// IssuedTokens returns the number of access tokens accepted by the userinfo
+// endpoint.
+func (s *Server) IssuedTokens() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.tokens)
+}
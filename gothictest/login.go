@@ -0,0 +1,249 @@
+package gothictest
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/markbates/goth"
+	"github.com/oov/gothic"
+)
+
+// Login is an authentication flow started by NewLogin which is waiting for
+// its callback.
+type Login struct {
+	// Gothic is the configuration the flow was started with, a copy of the
+	// one passed to NewLogin.
+	Gothic   *gothic.Gothic
+	Provider string
+	User     goth.User
+	// CallbackURL is the URL the callback requests are made to.
+	// It defaults to "/auth/{provider}/callback".
+	CallbackURL string
+	// State is the state parameter sent to the provider.
+	State string
+	// Cookie is the cookie set by gothic when the flow started.
+	Cookie *http.Cookie
+	// Cookies are the other cookies set when the flow started, such as the
+	// browser cookie of BindCookie. They are sent with every callback.
+	Cookies []*http.Cookie
+}
+
+// NewLogin starts a flow through GetAuthURL of a shallow copy of g whose
+// Providers only hold a provider named providerName authenticating every flow
+// as user, so g is not modified. Tenants of the copy is nil, since tenants
+// resolve their own providers. The flow is started by a POST request with a
+// CSRF token when CSRF protection is enabled. A Gothic with a JWECodec of a
+// random key is used when g is nil.
+func NewLogin(g *gothic.Gothic, providerName string, user goth.User) (*Login, error) {
+	cp := gothic.Gothic{Codec: &gothic.JWECodec{Key: securecookie.GenerateRandomKey(32)}}
+	if g != nil {
+		cp = *g
+	}
+	cp.Providers = gothic.NewRegistry(&userProvider{name: providerName, user: user})
+	cp.Tenants = nil
+	g = &cp
+
+	r, err := beginRequest(g, providerName)
+	if err != nil {
+		return nil, err
+	}
+	w := httptest.NewRecorder()
+	authURL, err := g.GetAuthURL(providerName, w, r)
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(authURL)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Login{
+		Gothic:      g,
+		Provider:    providerName,
+		User:        user,
+		CallbackURL: "/auth/" + providerName + "/callback",
+		State:       u.Query().Get("state"),
+	}
+	for _, c := range w.Result().Cookies() {
+		if c.Name == l.cookieName() {
+			l.Cookie = c
+		} else if c.MaxAge >= 0 {
+			l.Cookies = append(l.Cookies, c)
+		}
+	}
+	if l.Cookie == nil {
+		return nil, errors.New("gothictest: gothic did not set a cookie")
+	}
+	return l, nil
+}
+
+// beginRequest returns the request starting a flow, which is a POST request
+// with a CSRF token when CSRF protection is enabled.
+func beginRequest(g *gothic.Gothic, providerName string) (*http.Request, error) {
+	target := "/auth/" + providerName
+	if !g.CSRFProtection && !gothic.CSRFProtection {
+		return httptest.NewRequest("GET", target, nil), nil
+	}
+	w := httptest.NewRecorder()
+	token, err := g.CSRFToken(w, httptest.NewRequest("GET", target, nil))
+	if err != nil {
+		return nil, err
+	}
+	r := httptest.NewRequest("POST", target, nil)
+	r.Header.Set("X-Gothic-CSRF", token)
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(&http.Cookie{Name: c.Name, Value: c.Value})
+	}
+	return r, nil
+}
+
+func (l *Login) cookieName() string {
+	if l.Gothic.CookieName != "" {
+		return l.Gothic.CookieName
+	}
+	return gothic.CookieName
+}
+
+// Request returns the callback request which CompleteAuth of Gothic accepts.
+func (l *Login) Request() *http.Request {
+	return l.request(l.State, l.Cookie)
+}
+
+// Complete runs CompleteAuth of Gothic with Request and returns the
+// logged-in user.
+func (l *Login) Complete() (goth.User, error) {
+	return l.Gothic.CompleteAuth(l.Provider, httptest.NewRecorder(), l.Request())
+}
+
+// Tampered returns a callback request whose cookie value has been modified.
+func (l *Login) Tampered() *http.Request {
+	c := *l.Cookie
+	b := []byte(c.Value)
+	if b[len(b)/2] == 'A' {
+		b[len(b)/2] = 'B'
+	} else {
+		b[len(b)/2] = 'A'
+	}
+	c.Value = string(b)
+	return l.request(l.State, &c)
+}
+
+// Missing returns a callback request made after the browser discarded the
+// cookie.
+func (l *Login) Missing() *http.Request {
+	return l.request(l.State, nil)
+}
+
+// Expired returns a callback request whose cookie has expired but is still
+// sent by the browser. The cookie is encoded again with the key of Gothic,
+// so Codec of Gothic must be a JWTCodec or a JWECodec.
+func (l *Login) Expired() (*http.Request, error) {
+	var expired gothic.Codec
+	switch c := l.Gothic.Codec.(type) {
+	case *gothic.JWTCodec:
+		expired = &gothic.JWTCodec{Key: c.Key, MaxAge: -time.Second}
+	case *gothic.JWECodec:
+		expired = &gothic.JWECodec{Key: c.Key, MaxAge: -time.Second}
+	default:
+		return nil, errors.New("gothictest: Expired needs a JWTCodec or a JWECodec")
+	}
+	value, err := l.Gothic.Codec.Decode(l.Cookie.Name, l.Cookie.Value)
+	if err != nil {
+		return nil, err
+	}
+	c := *l.Cookie
+	if c.Value, err = expired.Encode(c.Name, value); err != nil {
+		return nil, err
+	}
+	return l.request(l.State, &c), nil
+}
+
+// StateMismatch returns a callback request whose state parameter does not
+// match the cookie.
+func (l *Login) StateMismatch() *http.Request {
+	state := []byte(l.State)
+	for i, j := 0, len(state)-1; i < j; i, j = i+1, j-1 {
+		state[i], state[j] = state[j], state[i]
+	}
+	if string(state) == l.State {
+		state = append(state, 'x')
+	}
+	return l.request(string(state), l.Cookie)
+}
+
+func (l *Login) request(state string, c *http.Cookie) *http.Request {
+	v := url.Values{"code": {"gothictest"}}
+	if state != "" {
+		v.Set("state", state)
+	}
+	sep := "?"
+	if strings.Contains(l.CallbackURL, "?") {
+		sep = "&"
+	}
+	r := httptest.NewRequest("GET", l.CallbackURL+sep+v.Encode(), nil)
+	for _, c := range l.Cookies {
+		r.AddCookie(&http.Cookie{Name: c.Name, Value: c.Value})
+	}
+	if c != nil {
+		r.AddCookie(&http.Cookie{Name: c.Name, Value: c.Value})
+	}
+	return r
+}
+
+type userProvider struct {
+	name string
+	user goth.User
+}
+
+func (p *userProvider) Name() string {
+	return p.name
+}
+
+func (p *userProvider) Debug(debug bool) {}
+
+func (p *userProvider) Protocol() gothic.Protocol {
+	return gothic.OAuth2
+}
+
+func (p *userProvider) BeginAuth(state string) (goth.Session, error) {
+	return &userSession{AuthURL: "http://gothictest.invalid/authorize?" + url.Values{"state": {state}}.Encode()}, nil
+}
+
+func (p *userProvider) UnmarshalSession(data string) (goth.Session, error) {
+	s := &userSession{}
+	return s, json.NewDecoder(strings.NewReader(data)).Decode(s)
+}
+
+func (p *userProvider) FetchUser(session goth.Session) (goth.User, error) {
+	if session.(*userSession).Code == "" {
+		return goth.User{}, errors.New("gothictest: session is not authorized")
+	}
+	user := p.user
+	user.Provider = p.name
+	return user, nil
+}
+
+type userSession struct {
+	AuthURL string
+	Code    string
+}
+
+func (s *userSession) GetAuthURL() (string, error) {
+	return s.AuthURL, nil
+}
+
+func (s *userSession) Marshal() string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+func (s *userSession) Authorize(provider goth.Provider, params goth.Params) (string, error) {
+	s.Code = params.Get("code")
+	return s.Code, nil
+}
@@ -0,0 +1,95 @@
+package gothictest_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/markbates/goth"
+	"github.com/oov/gothic"
+	"github.com/oov/gothic/gothictest"
+)
+
+var loginUser = goth.User{
+	UserID: "42",
+	Email:  "login@example.com",
+	Name:   "Login User",
+}
+
+func TestLogin(t *testing.T) {
+	g := &gothic.Gothic{}
+	l, err := gothictest.NewLogin(g, "login", loginUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = goth.GetProvider("login"); err == nil {
+		t.Error("expected the provider not to be registered in goth")
+	}
+	if g.Providers != nil {
+		t.Error("expected the Gothic of the caller not to be modified")
+	}
+	user, err := l.Complete()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Provider != "login" {
+		t.Errorf("expected user.Provider value %q got %q", "login", user.Provider)
+	}
+	if user.UserID != loginUser.UserID {
+		t.Errorf("expected user.UserID value %q got %q", loginUser.UserID, user.UserID)
+	}
+	if user.Email != loginUser.Email {
+		t.Errorf("expected user.Email value %q got %q", loginUser.Email, user.Email)
+	}
+}
+
+func TestLoginBindingCSRF(t *testing.T) {
+	g := &gothic.Gothic{Binding: gothic.BindCookie | gothic.BindUserAgent, CSRFProtection: true}
+	l, err := gothictest.NewLogin(g, "login", loginUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = l.Complete(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoginNegative(t *testing.T) {
+	l, err := gothictest.NewLogin(nil, "login", loginUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := l.Expired()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]struct {
+		r   *http.Request
+		msg string
+	}{
+		"tampered":       {l.Tampered(), "gothic: the value is not a valid token"},
+		"missing":        {l.Missing(), "http: named cookie not present"},
+		"expired":        {expired, "gothic: the token has expired"},
+		"state mismatch": {l.StateMismatch(), "oauth 2.0 state parameter does not match"},
+	}
+	for name, tt := range tests {
+		_, err := l.Gothic.CompleteAuth(l.Provider, httptest.NewRecorder(), tt.r)
+		if err == nil {
+			t.Errorf("%s: expected error got none", name)
+			continue
+		}
+		if err.Error() != tt.msg {
+			t.Errorf("%s: expected %q got %q", name, tt.msg, err)
+		}
+	}
+}
+
+func TestLoginExpiredSecureCookie(t *testing.T) {
+	l, err := gothictest.NewLogin(&gothic.Gothic{}, "login", loginUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = l.Expired(); err == nil {
+		t.Error("expected an error for securecookie codecs")
+	}
+}
@@ -0,0 +1,33 @@
+package gothictest
+
+import (
+	"github.com/oov/gothic"
+)
+
+// OAuth2Config returns the configuration of a gothic.OAuth2Provider which
+// authenticates users against s and redirects them back to callbackURL.
+func (s *Server) OAuth2Config(callbackURL string) gothic.OAuth2Config {
+	return gothic.OAuth2Config{
+		ClientID:     s.ClientID,
+		ClientSecret: s.ClientSecret,
+		AuthURL:      s.EndpointURL(Authorize),
+		TokenURL:     s.EndpointURL(Token),
+		UserInfoURL:  s.EndpointURL(UserInfo),
+		CallbackURL:  callbackURL,
+		Scopes:       []string{"openid", "email", "profile"},
+		Claims:       gothic.StandardClaims,
+		HTTPClient:   s.Client(),
+	}
+}
+
+// Provider returns a gothic.OAuth2Provider named name which authenticates
+// users against s and redirects them back to callbackURL.
+func (s *Server) Provider(name, callbackURL string) *gothic.OAuth2Provider {
+	return gothic.NewOAuth2Provider(name, s.OAuth2Config(callbackURL))
+}
+
+// OIDCProvider returns a gothic.OIDCProvider named name which is configured
+// by the discovery document of s and redirects users back to callbackURL.
+func (s *Server) OIDCProvider(name, callbackURL string) (*gothic.OIDCProvider, error) {
+	return gothic.NewOIDCProviderWithClient(s.Client(), name, s.Issuer(), s.ClientID, s.ClientSecret, callbackURL)
+}
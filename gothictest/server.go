@@ -0,0 +1,470 @@
+// Package gothictest provides a local OAuth 2.0 / OpenID Connect authorization
+// server for testing applications built on gothic.
+//
+// The server approves every authorization request on behalf of a scripted
+// user, so a whole BeginAuth -> callback -> CompleteAuth flow can be driven
+// from a test without network access.
+package gothictest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/securecookie"
+)
+
+// Endpoint identifies an endpoint of the Server.
+type Endpoint string
+
+// Endpoints served by the Server.
+const (
+	Authorize Endpoint = "/authorize"
+	Token     Endpoint = "/token"
+	UserInfo  Endpoint = "/userinfo"
+	JWKS      Endpoint = "/jwks"
+	Discovery Endpoint = "/.well-known/openid-configuration"
+)
+
+// User is an account of the Server.
+type User struct {
+	ID            string
+	Email         string
+	EmailVerified bool
+	Name          string
+	NickName      string
+	AvatarURL     string
+	// Claims is merged into the userinfo response and the ID token.
+	Claims map[string]interface{}
+}
+
+// Failure describes a failure injected into an endpoint by FailNext.
+type Failure struct {
+	// Status is the HTTP status code of the response.
+	// Status=0 on the authorize endpoint redirects back to the client with Error,
+	// otherwise it defaults to http.StatusBadRequest.
+	Status int
+	// Error is the OAuth 2.0 error code such as "access_denied".
+	Error       string
+	Description string
+}
+
+// Server is a local OAuth 2.0 / OpenID Connect authorization server.
+type Server struct {
+	*httptest.Server
+
+	ClientID     string
+	ClientSecret string
+
+	// TokenLifetime is the lifetime of the issued access and ID tokens.
+	TokenLifetime time.Duration
+
+	// IDToken, when not nil, is used instead of SignIDToken to produce the ID
+	// token of token responses, so that tests can tamper with its claims or
+	// forge it.
+	IDToken func(claims map[string]interface{}) (string, error)
+
+	key   *rsa.PrivateKey
+	keyID string
+
+	mu       sync.Mutex
+	users    map[string]User
+	current  string
+	grants   map[string]grant
+	tokens   map[string]string
+	failures map[Endpoint][]Failure
+	requests map[Endpoint]int
+}
+
+type grant struct {
+	userID      string
+	redirectURI string
+	nonce       string
+	expires     time.Time
+}
+
+// NewServer starts and returns a new Server.
+// The caller should call Close when finished, to shut it down.
+func NewServer() *Server {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	s := &Server{
+		ClientID:      "gothictest",
+		ClientSecret:  randomString(24),
+		TokenLifetime: time.Hour,
+		key:           key,
+		keyID:         randomString(8),
+		users:         map[string]User{},
+		grants:        map[string]grant{},
+		tokens:        map[string]string{},
+		failures:      map[Endpoint][]Failure{},
+		requests:      map[Endpoint]int{},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(string(Authorize), s.counted(Authorize, s.authorize))
+	mux.HandleFunc(string(Token), s.counted(Token, s.token))
+	mux.HandleFunc(string(UserInfo), s.counted(UserInfo, s.userInfo))
+	mux.HandleFunc(string(JWKS), s.counted(JWKS, s.jwks))
+	mux.HandleFunc(string(Discovery), s.counted(Discovery, s.discovery))
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// Issuer returns the issuer identifier of the Server.
+func (s *Server) Issuer() string {
+	return s.URL
+}
+
+// EndpointURL returns the absolute URL of e.
+func (s *Server) EndpointURL(e Endpoint) string {
+	return s.URL + string(e)
+}
+
+// RotateKey replaces the signing key of ID tokens with a new one, which is
+// published on the JWKS endpoint under a new key ID.
+func (s *Server) RotateKey() {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	s.mu.Lock()
+	s.key, s.keyID = key, randomString(8)
+	s.mu.Unlock()
+}
+
+// AddUser registers u. The first registered user approves authorization
+// requests until Login selects another one.
+func (s *Server) AddUser(u User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[u.ID] = u
+	if s.current == "" {
+		s.current = u.ID
+	}
+}
+
+// Login makes the user identified by id approve subsequent authorization
+// requests. An empty id makes them fail with "login_required".
+func (s *Server) Login(id string) {
+	s.mu.Lock()
+	s.current = id
+	s.mu.Unlock()
+}
+
+// FailNext makes the next request to e fail with f.
+// Multiple calls are queued and consumed in order.
+func (s *Server) FailNext(e Endpoint, f Failure) {
+	s.mu.Lock()
+	s.failures[e] = append(s.failures[e], f)
+	s.mu.Unlock()
+}
+
+// Requests returns the number of requests e has received.
+func (s *Server) Requests(e Endpoint) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[e]
+}
+
+// Authorize plays the role of the browser on the authorization endpoint.
+// It requests authURL and returns the callback URL the server redirected to.
+func (s *Server) Authorize(authURL string) (*url.URL, error) {
+	if !strings.HasPrefix(authURL, s.EndpointURL(Authorize)) {
+		return nil, fmt.Errorf("gothictest: %q is not the authorization endpoint of the server", authURL)
+	}
+	c := *s.Client()
+	c.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	resp, err := c.Get(authURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		return nil, fmt.Errorf("gothictest: authorization endpoint returned %s", resp.Status)
+	}
+	return resp.Location()
+}
+
+func (s *Server) counted(e Endpoint, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests[e]++
+		s.mu.Unlock()
+		h(w, r)
+	}
+}
+
+func (s *Server) nextFailure(e Endpoint) (Failure, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q := s.failures[e]
+	if len(q) == 0 {
+		return Failure{}, false
+	}
+	s.failures[e] = q[1:]
+	return q[0], true
+}
+
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != s.ClientID {
+		writeError(w, http.StatusBadRequest, Failure{Error: "unauthorized_client", Description: "unknown client_id"})
+		return
+	}
+	redirectURI, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || !redirectURI.IsAbs() {
+		writeError(w, http.StatusBadRequest, Failure{Error: "invalid_request", Description: "invalid redirect_uri"})
+		return
+	}
+
+	v := redirectURI.Query()
+	if state := q.Get("state"); state != "" {
+		v.Set("state", state)
+	}
+	redirect := func() {
+		redirectURI.RawQuery = v.Encode()
+		http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+	}
+
+	if f, ok := s.nextFailure(Authorize); ok {
+		if f.Status != 0 {
+			writeError(w, f.Status, f)
+			return
+		}
+		v.Set("error", f.Error)
+		if f.Description != "" {
+			v.Set("error_description", f.Description)
+		}
+		redirect()
+		return
+	}
+	if q.Get("response_type") != "code" {
+		v.Set("error", "unsupported_response_type")
+		redirect()
+		return
+	}
+
+	s.mu.Lock()
+	userID := s.current
+	if hint := q.Get("login_hint"); hint != "" {
+		userID = hint
+	}
+	_, found := s.users[userID]
+	code := randomString(24)
+	if found {
+		s.grants[code] = grant{
+			userID:      userID,
+			redirectURI: q.Get("redirect_uri"),
+			nonce:       q.Get("nonce"),
+			expires:     time.Now().Add(time.Minute),
+		}
+	}
+	s.mu.Unlock()
+
+	if !found {
+		v.Set("error", "login_required")
+		redirect()
+		return
+	}
+	v.Set("code", code)
+	redirect()
+}
+
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	if f, ok := s.nextFailure(Token); ok {
+		writeError(w, f.Status, f)
+		return
+	}
+	if r.Method != "POST" {
+		writeError(w, http.StatusMethodNotAllowed, Failure{Error: "invalid_request"})
+		return
+	}
+
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID, clientSecret = r.PostFormValue("client_id"), r.PostFormValue("client_secret")
+	}
+	if clientID != s.ClientID || clientSecret != s.ClientSecret {
+		writeError(w, http.StatusUnauthorized, Failure{Error: "invalid_client"})
+		return
+	}
+	if r.PostFormValue("grant_type") != "authorization_code" {
+		writeError(w, http.StatusBadRequest, Failure{Error: "unsupported_grant_type"})
+		return
+	}
+
+	s.mu.Lock()
+	code := r.PostFormValue("code")
+	g, found := s.grants[code]
+	delete(s.grants, code)
+	if !found || time.Now().After(g.expires) || r.PostFormValue("redirect_uri") != g.redirectURI {
+		s.mu.Unlock()
+		writeError(w, http.StatusBadRequest, Failure{Error: "invalid_grant"})
+		return
+	}
+	u := s.users[g.userID]
+	accessToken := randomString(32)
+	s.tokens[accessToken] = g.userID
+	s.mu.Unlock()
+
+	now := time.Now()
+	claims := s.claims(u)
+	claims["iss"] = s.Issuer()
+	claims["aud"] = s.ClientID
+	claims["azp"] = s.ClientID
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(s.TokenLifetime).Unix()
+	if g.nonce != "" {
+		claims["nonce"] = g.nonce
+	}
+	sign := s.SignIDToken
+	if s.IDToken != nil {
+		sign = s.IDToken
+	}
+	idToken, err := sign(claims)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, Failure{Error: "server_error", Description: err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   int(s.TokenLifetime / time.Second),
+		"id_token":     idToken,
+	})
+}
+
+func (s *Server) userInfo(w http.ResponseWriter, r *http.Request) {
+	if f, ok := s.nextFailure(UserInfo); ok {
+		writeError(w, f.Status, f)
+		return
+	}
+
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, http.StatusUnauthorized, Failure{Error: "invalid_token"})
+		return
+	}
+
+	s.mu.Lock()
+	userID, found := s.tokens[auth[len("Bearer "):]]
+	u := s.users[userID]
+	s.mu.Unlock()
+
+	if !found {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		writeError(w, http.StatusUnauthorized, Failure{Error: "invalid_token"})
+		return
+	}
+	writeJSON(w, http.StatusOK, s.claims(u))
+}
+
+func (s *Server) jwks(w http.ResponseWriter, r *http.Request) {
+	if f, ok := s.nextFailure(JWKS); ok {
+		writeError(w, f.Status, f)
+		return
+	}
+	s.mu.Lock()
+	key, keyID := s.key, s.keyID
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": keyID,
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(bigEndian(key.E)),
+		}},
+	})
+}
+
+func (s *Server) discovery(w http.ResponseWriter, r *http.Request) {
+	if f, ok := s.nextFailure(Discovery); ok {
+		writeError(w, f.Status, f)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                s.Issuer(),
+		"authorization_endpoint":                s.EndpointURL(Authorize),
+		"token_endpoint":                        s.EndpointURL(Token),
+		"userinfo_endpoint":                     s.EndpointURL(UserInfo),
+		"jwks_uri":                              s.EndpointURL(JWKS),
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"scopes_supported":                      []string{"openid", "email", "profile"},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post"},
+		"claims_supported":                      []string{"sub", "email", "email_verified", "name", "preferred_username", "picture"},
+	})
+}
+
+func (s *Server) claims(u User) map[string]interface{} {
+	m := map[string]interface{}{
+		"sub": u.ID,
+	}
+	if u.Email != "" {
+		m["email"] = u.Email
+		m["email_verified"] = u.EmailVerified
+	}
+	if u.Name != "" {
+		m["name"] = u.Name
+	}
+	if u.NickName != "" {
+		m["preferred_username"] = u.NickName
+	}
+	if u.AvatarURL != "" {
+		m["picture"] = u.AvatarURL
+	}
+	for k, v := range u.Claims {
+		m[k] = v
+	}
+	return m
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, f Failure) {
+	if status == 0 {
+		status = http.StatusBadRequest
+	}
+	if f.Error == "" {
+		f.Error = "server_error"
+	}
+	v := map[string]string{"error": f.Error}
+	if f.Description != "" {
+		v["error_description"] = f.Description
+	}
+	writeJSON(w, status, v)
+}
+
+func randomString(n int) string {
+	return base64.RawURLEncoding.EncodeToString(securecookie.GenerateRandomKey(n))
+}
+
+func bigEndian(n int) []byte {
+	var b []byte
+	for ; n > 0; n >>= 8 {
+		b = append([]byte{byte(n)}, b...)
+	}
+	return b
+}
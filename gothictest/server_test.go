@@ -0,0 +1,180 @@
+package gothictest_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/markbates/goth"
+	"github.com/oov/gothic"
+	"github.com/oov/gothic/gothictest"
+)
+
+const (
+	providerName = "gothictest"
+	callbackURL  = "http://app.example.com/auth/gothictest/callback"
+)
+
+var alice = gothictest.User{
+	ID:            "alice",
+	Email:         "alice@example.com",
+	EmailVerified: true,
+	Name:          "Alice Liddell",
+	NickName:      "alice",
+}
+
+func newServer() *gothictest.Server {
+	s := gothictest.NewServer()
+	s.AddUser(alice)
+	goth.UseProviders(s.Provider(providerName, callbackURL))
+	return s
+}
+
+func login(s *gothictest.Server) (goth.User, error) {
+	w, r := httptest.NewRecorder(), httptest.NewRequest("GET", "/auth/gothictest", nil)
+	authURL, err := gothic.GetAuthURL(providerName, w, r)
+	if err != nil {
+		return goth.User{}, err
+	}
+	callback, err := s.Authorize(authURL)
+	if err != nil {
+		return goth.User{}, err
+	}
+	r = httptest.NewRequest("GET", callback.String(), nil)
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	return gothic.CompleteAuth(providerName, httptest.NewRecorder(), r)
+}
+
+func TestFlow(t *testing.T) {
+	s := newServer()
+	defer s.Close()
+
+	user, err := login(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Provider != providerName {
+		t.Errorf("expected user.Provider value %q got %q", providerName, user.Provider)
+	}
+	if user.UserID != alice.ID {
+		t.Errorf("expected user.UserID value %q got %q", alice.ID, user.UserID)
+	}
+	if user.Email != alice.Email {
+		t.Errorf("expected user.Email value %q got %q", alice.Email, user.Email)
+	}
+	if user.NickName != alice.NickName {
+		t.Errorf("expected user.NickName value %q got %q", alice.NickName, user.NickName)
+	}
+	if user.AccessToken == "" {
+		t.Error("expected access token got none")
+	}
+	for _, e := range []gothictest.Endpoint{gothictest.Authorize, gothictest.Token, gothictest.UserInfo} {
+		if n := s.Requests(e); n != 1 {
+			t.Errorf("expected 1 request to %s got %d", e, n)
+		}
+	}
+}
+
+func TestFlowLogin(t *testing.T) {
+	s := newServer()
+	defer s.Close()
+	s.AddUser(gothictest.User{ID: "bob"})
+	s.Login("bob")
+
+	user, err := login(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.UserID != "bob" {
+		t.Errorf("expected user.UserID value %q got %q", "bob", user.UserID)
+	}
+
+	s.Login("")
+	if _, err = login(s); err == nil || !strings.Contains(err.Error(), "login_required") {
+		t.Errorf("expected login_required error got %v", err)
+	}
+}
+
+func TestFlowFailure(t *testing.T) {
+	s := newServer()
+	defer s.Close()
+
+	tests := []struct {
+		endpoint gothictest.Endpoint
+		failure  gothictest.Failure
+		msg      string
+	}{
+		{gothictest.Authorize, gothictest.Failure{Error: "access_denied"}, "access_denied"},
+		{gothictest.Authorize, gothictest.Failure{Status: http.StatusServiceUnavailable}, "503"},
+		{gothictest.Token, gothictest.Failure{Status: http.StatusInternalServerError}, "500"},
+		{gothictest.Token, gothictest.Failure{Error: "invalid_grant"}, "invalid_grant"},
+		{gothictest.UserInfo, gothictest.Failure{Status: http.StatusUnauthorized, Error: "invalid_token"}, "invalid_token"},
+	}
+	for _, tt := range tests {
+		s.FailNext(tt.endpoint, tt.failure)
+		_, err := login(s)
+		if err == nil {
+			t.Errorf("%s: expected error got none", tt.endpoint)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.msg) {
+			t.Errorf("%s: expected %q in error got %q", tt.endpoint, tt.msg, err)
+		}
+	}
+
+	if _, err := login(s); err != nil {
+		t.Errorf("expected failures to be consumed got %v", err)
+	}
+}
+
+func TestTokenRejectedExchange(t *testing.T) {
+	s := newServer()
+	defer s.Close()
+
+	w, r := httptest.NewRecorder(), httptest.NewRequest("GET", "/auth/gothictest", nil)
+	authURL, err := gothic.GetAuthURL(providerName, w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	callback, err := s.Authorize(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.PostForm(s.EndpointURL(gothictest.Token), url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {callback.Query().Get("code")},
+		"redirect_uri":  {"http://evil.example.com/callback"},
+		"client_id":     {s.ClientID},
+		"client_secret": {s.ClientSecret},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected %d got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+	if n := s.IssuedTokens(); n != 0 {
+		t.Errorf("expected no access token got %d", n)
+	}
+}
+
+func TestDiscovery(t *testing.T) {
+	s := newServer()
+	defer s.Close()
+
+	for _, e := range []gothictest.Endpoint{gothictest.Discovery, gothictest.JWKS} {
+		resp, err := s.Client().Get(s.EndpointURL(e))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%s: expected status code %d got %d", e, http.StatusOK, resp.StatusCode)
+		}
+	}
+}
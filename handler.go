@@ -0,0 +1,223 @@
+package gothic
+
+import (
+	"crypto/sha256"
+	"errors"
+	"html/template"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/markbates/goth"
+)
+
+// RecoveryPolicy is how Handler responds to a callback without a valid flow
+// cookie, which happens when the callback URL is bookmarked, revisited with
+// the back button, or the browser dropped the cookie.
+type RecoveryPolicy int
+
+const (
+	// NoRecovery passes the *FlowCookieError to Handler.Error.
+	NoRecovery RecoveryPolicy = iota
+	// RestartFlow starts a new flow with the same provider. A client is
+	// restarted once within RestartWindow; the explanation page is rendered
+	// instead when the restarted flow fails again, so a browser which never
+	// keeps the cookie does not loop.
+	RestartFlow
+	// ExplainFailure renders a page explaining the likely causes.
+	ExplainFailure
+)
+
+// RestartWindow is how long Handler remembers restarts for its loop guard.
+var RestartWindow = time.Minute
+
+// Handler serves the flows of Gothic at Prefix+"{provider}" and
+// Prefix+"{provider}/callback". When CSRF protection is enabled, flows are
+// started by login forms posting to Prefix+"{provider}" with CSRFField.
+type Handler struct {
+	// Gothic is the configuration of the flows. The package level
+	// configuration is used when nil.
+	Gothic *Gothic
+	// Prefix is the path of the handler as requested by browsers.
+	// "/auth/" is used when empty.
+	Prefix string
+	// Success is called with the user when a flow completes.
+	Success func(w http.ResponseWriter, r *http.Request, user goth.User)
+	// Error is called when a flow fails. The error is written as a plain
+	// text response when nil.
+	Error func(w http.ResponseWriter, r *http.Request, err error)
+	// Recovery is applied when the flow cookie is missing or does not decode
+	// on callback.
+	Recovery RecoveryPolicy
+
+	restarts restartGuard
+}
+
+func (h *Handler) gothic() *Gothic {
+	if h.Gothic != nil {
+		return h.Gothic
+	}
+	return defaultGothic
+}
+
+func (h *Handler) prefix() string {
+	if h.Prefix != "" {
+		return h.Prefix
+	}
+	return "/auth/"
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+	if !strings.HasPrefix(path, h.prefix()) {
+		http.NotFound(w, r)
+		return
+	}
+	ss := strings.Split(path[len(h.prefix()):], "/")
+	switch {
+	case len(ss) == 1 && ss[0] != "":
+		h.begin(w, r, ss[0])
+	case len(ss) == 2 && ss[0] != "" && ss[1] == "callback":
+		h.callback(w, r, ss[0])
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (h *Handler) begin(w http.ResponseWriter, r *http.Request, providerName string) {
+	if err := h.gothic().BeginAuth(providerName, w, r); err != nil {
+		h.error(w, r, err)
+	}
+}
+
+func (h *Handler) callback(w http.ResponseWriter, r *http.Request, providerName string) {
+	user, err := h.gothic().CompleteAuth(providerName, w, r)
+	if err != nil {
+		var ce *FlowCookieError
+		if errors.As(err, &ce) && h.recover(w, r, providerName, ce) {
+			return
+		}
+		h.error(w, r, err)
+		return
+	}
+	if h.Success == nil {
+		http.Error(w, "gothic: Handler has no Success", http.StatusInternalServerError)
+		return
+	}
+	h.Success(w, r, user)
+}
+
+func (h *Handler) error(w http.ResponseWriter, r *http.Request, err error) {
+	if h.Error != nil {
+		h.Error(w, r, err)
+		return
+	}
+	var be *BindingError
+	if errors.As(err, &be) || errors.Is(err, ErrAccessDenied) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	var le *RateLimitError
+	if errors.As(err, &le) {
+		w.Header().Set("Retry-After", le.retryAfter())
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	}
+	switch err {
+	case ErrMethodNotAllowed:
+		w.Header().Set("Allow", "POST")
+		http.Error(w, err.Error(), http.StatusMethodNotAllowed)
+	case ErrCSRFToken:
+		http.Error(w, err.Error(), http.StatusForbidden)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// recover applies the recovery policy and reports whether it responded.
+func (h *Handler) recover(w http.ResponseWriter, r *http.Request, providerName string, e *FlowCookieError) bool {
+	g := h.gothic()
+	switch h.Recovery {
+	case RestartFlow:
+		// flows protected from CSRF cannot be restarted by a redirect
+		if !g.csrfProtection() && h.restarts.allow(g.clientKey(r, providerName)) {
+			http.Redirect(w, r, h.prefix()+providerName, http.StatusFound)
+			return true
+		}
+		fallthrough
+	case ExplainFailure:
+		data := map[string]interface{}{
+			"Missing":  e.Missing,
+			"RetryURL": h.prefix() + providerName,
+		}
+		if g.csrfProtection() {
+			field, err := g.CSRFField(w, r)
+			if err != nil {
+				return false
+			}
+			data["CSRFField"] = field
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusBadRequest)
+		recoveryPage.Execute(w, data)
+		return true
+	}
+	return false
+}
+
+// clientKey identifies the browser of r for the loop guard without cookies,
+// which may be what is failing. The address is taken from the forwarding
+// headers of the proxies, so clients behind the same proxy are told apart.
+func (g *Gothic) clientKey(r *http.Request, providerName string) string {
+	h := sha256.Sum256([]byte(g.proxies().clientAddr(r) + "\x00" + r.UserAgent() + "\x00" + providerName))
+	return string(h[:])
+}
+
+// restartGuard remembers the clients restarted within RestartWindow.
+type restartGuard struct {
+	mu       sync.Mutex
+	restarts map[string]time.Time
+}
+
+func (g *restartGuard) allow(key string) bool {
+	now := time.Now()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.restarts == nil {
+		g.restarts = map[string]time.Time{}
+	}
+	for k, exp := range g.restarts {
+		if !now.Before(exp) {
+			delete(g.restarts, k)
+		}
+	}
+	if _, ok := g.restarts[key]; ok {
+		return false
+	}
+	g.restarts[key] = now.Add(RestartWindow)
+	return true
+}
+
+var recoveryPage = template.Must(template.New("").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Sign-in could not be completed</title></head>
+<body>
+<h1>Sign-in could not be completed</h1>
+{{if .Missing}}<p>Your browser did not send back the cookie which was set when the sign-in started.</p>
+{{else}}<p>The sign-in information kept by your browser is invalid or has expired.</p>
+{{end}}<p>This usually happens when:</p>
+<ul>
+<li>this page was opened from a bookmark, the history or the back button instead of by the sign-in;</li>
+<li>the browser blocks cookies for this site, or third-party cookies when the site is embedded in another one;</li>
+<li>the cookie settings of the site, such as SameSite, Secure or Domain, keep the browser from sending the cookie back;</li>
+<li>the sign-in was started on another address of this site, or took too long.</li>
+</ul>
+{{if .CSRFField}}<form method="post" action="{{.RetryURL}}">{{.CSRFField}}<button type="submit">Sign in again</button></form>
+{{else}}<p><a href="{{.RetryURL}}">Sign in again</a></p>
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,108 @@
+package gothic
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/markbates/goth"
+)
+
+func newTestHandler(recovery RecoveryPolicy) *Handler {
+	return &Handler{
+		Recovery: recovery,
+		Success: func(w http.ResponseWriter, r *http.Request, user goth.User) {
+			w.Write([]byte(user.Email))
+		},
+	}
+}
+
+func TestHandler(t *testing.T) {
+	h := newTestHandler(NoRecovery)
+	w, r := wr("GET", "/auth/"+providerName, nil)
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusTemporaryRedirect {
+		t.Fatalf("expected %d got %d", http.StatusTemporaryRedirect, w.Code)
+	}
+	sc := w.Header().Get("Set-Cookie")
+
+	w, r = wr("GET", "/auth/"+providerName+"/callback?state="+lastState, nil)
+	r.Header.Set("Cookie", sc[:strings.Index(sc, ";")])
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Body.String() != userEmail {
+		t.Errorf("expected the user got %d %q", w.Code, w.Body.String())
+	}
+
+	for _, path := range []string{"/auth/", "/auth/a/b", "/other/" + providerName} {
+		w, r = wr("GET", path, nil)
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%s: expected %d got %d", path, http.StatusNotFound, w.Code)
+		}
+	}
+
+	w, r = wr("GET", "/auth/"+providerName+"/callback", nil)
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), "named cookie not present") {
+		t.Errorf("expected the raw error got %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestHandlerExplainFailure(t *testing.T) {
+	h := newTestHandler(ExplainFailure)
+	w, r := wr("GET", "/auth/"+providerName+"/callback", nil)
+	r.Header.Set("Cookie", CookieName+"=broken")
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected %d got %d", http.StatusBadRequest, w.Code)
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, "invalid or has expired") || !strings.Contains(body, `href="/auth/`+providerName+`"`) {
+		t.Errorf("unexpected page %s", body)
+	}
+}
+
+func TestHandlerRestartFlow(t *testing.T) {
+	h := newTestHandler(RestartFlow)
+	callback := func(ua string) *http.Response {
+		w, r := wr("GET", "/auth/"+providerName+"/callback", nil)
+		r.Header.Set("User-Agent", ua)
+		h.ServeHTTP(w, r)
+		return w.Result()
+	}
+
+	resp := callback("a")
+	if resp.StatusCode != http.StatusFound || resp.Header.Get("Location") != "/auth/"+providerName {
+		t.Fatalf("expected a restart got %d %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	// the second failure within RestartWindow is explained instead of looping
+	if resp = callback("a"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected %d got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+	if resp = callback("b"); resp.StatusCode != http.StatusFound {
+		t.Errorf("expected another client to restart got %d", resp.StatusCode)
+	}
+}
+
+func TestHandlerRestartFlowBehindProxy(t *testing.T) {
+	ps, _ := ParseProxies("192.0.2.0/24")
+	h := newTestHandler(RestartFlow)
+	h.Gothic = &Gothic{TrustedProxies: ps}
+	callback := func(client string) int {
+		w, r := wr("GET", "/auth/"+providerName+"/callback", nil)
+		r.RemoteAddr = "192.0.2.1:1234"
+		r.Header.Set("X-Forwarded-For", client)
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if code := callback("198.51.100.1"); code != http.StatusFound {
+		t.Fatalf("expected a restart got %d", code)
+	}
+	if code := callback("198.51.100.2"); code != http.StatusFound {
+		t.Errorf("expected another client behind the proxy to restart got %d", code)
+	}
+	if code := callback("198.51.100.1"); code != http.StatusBadRequest {
+		t.Errorf("expected %d got %d", http.StatusBadRequest, code)
+	}
+}
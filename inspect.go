@@ -0,0 +1,48 @@
+package gothic
+
+import (
+	"net/http"
+	"time"
+)
+
+// Flow is the content of a flow cookie, as returned by InspectFlow.
+type Flow struct {
+	State    string
+	Provider string
+	Tenant   string
+	// Provider, Tenant and IssuedAt are empty for the legacy format.
+	IssuedAt time.Time
+	// Session is the session marshaled by the provider, usually JSON.
+	Session string
+	Extras  map[string]string
+	// Legacy is set for cookies of the legacy format, which does not record
+	// Provider, Tenant and IssuedAt.
+	Legacy bool
+}
+
+// InspectFlow decodes the flow cookie sent in r without completing or
+// deleting it. It is meant for debugging.
+func (g *Gothic) InspectFlow(r *http.Request) (*Flow, error) {
+	name := g.cookieName()
+	encoded, err := readChunkedCookie(r, name, g.maxCookieSize())
+	if err != nil {
+		return nil, err
+	}
+	p, err := g.decode(loadConfig(), name, encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &Flow{
+		State:    p.State,
+		Provider: p.Provider,
+		Tenant:   p.Tenant,
+		Session:  p.Session,
+		Extras:   p.Extras,
+		Legacy:   p.legacy,
+	}
+	if !p.legacy {
+		f.IssuedAt = time.Unix(p.IssuedAt, 0)
+	}
+	return f, nil
+}
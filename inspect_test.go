@@ -0,0 +1,25 @@
+package gothic
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInspectFlow(t *testing.T) {
+	cookie := beginAuthCookie()
+	w, r := wr("GET", "/", nil)
+	r.Header.Set("Cookie", cookie)
+	f, err := defaultGothic.InspectFlow(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.State != lastState || f.Provider != providerName || f.Legacy {
+		t.Errorf("unexpected flow %+v", f)
+	}
+	if time.Since(f.IssuedAt) > time.Minute {
+		t.Errorf("expected a recent IssuedAt got %v", f.IssuedAt)
+	}
+	if len(w.Header()["Set-Cookie"]) != 0 {
+		t.Error("expected the cookie to be kept")
+	}
+}
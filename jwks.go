@@ -0,0 +1,240 @@
+package gothic
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256" // for crypto.SHA256
+	_ "crypto/sha512" // for crypto.SHA384 and crypto.SHA512
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jwksCache caches the keys of a JWKS endpoint.
+type jwksCache struct {
+	url     string
+	client  func() *http.Client
+	refresh time.Duration
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+	// fetching is closed when the fetch in progress completes, setting
+	// fetchErr.
+	fetching chan struct{}
+	fetchErr error
+}
+
+// minJWKSRefresh limits how often an unknown key ID refetches the keys.
+var minJWKSRefresh = time.Minute
+
+// key returns the key identified by kid, refetching the keys when they are
+// stale or kid is unknown. The keys are fetched without holding the lock, so
+// known keys are served while the endpoint is slow.
+func (c *jwksCache) key(kid string) (crypto.PublicKey, error) {
+	c.mu.Lock()
+	age := time.Since(c.fetchedAt)
+	k, ok := c.lookup(kid)
+	if ok && age < c.refresh {
+		c.mu.Unlock()
+		return k, nil
+	}
+	if c.keys != nil && age < c.refresh && (ok || age < minJWKSRefresh) {
+		c.mu.Unlock()
+		return nil, fmt.Errorf("gothic: unknown signing key %q", kid)
+	}
+	if ok && c.fetching != nil {
+		// keep using the known key while another request refreshes the keys
+		c.mu.Unlock()
+		return k, nil
+	}
+
+	done := c.fetching
+	if done == nil {
+		done = make(chan struct{})
+		c.fetching = done
+		c.mu.Unlock()
+		keys, err := c.fetch()
+		c.mu.Lock()
+		if err == nil {
+			c.keys = keys
+			c.fetchedAt = time.Now()
+		}
+		c.fetchErr = err
+		c.fetching = nil
+		close(done)
+	} else {
+		c.mu.Unlock()
+		<-done
+		c.mu.Lock()
+	}
+	err := c.fetchErr
+	if nk, nok := c.lookup(kid); nok {
+		k, ok = nk, true
+	}
+	c.mu.Unlock()
+
+	if ok {
+		// a known key is kept while the endpoint is unavailable
+		return k, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("gothic: unknown signing key %q", kid)
+}
+
+func (c *jwksCache) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(c.keys) == 1 {
+		for _, k := range c.keys {
+			return k, true
+		}
+	}
+	k, ok := c.keys[kid]
+	return k, ok
+}
+
+func (c *jwksCache) fetch() (map[string]crypto.PublicKey, error) {
+	resp, err := c.client().Get(c.url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError("jwks", resp)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, err
+	}
+	keys := map[string]crypto.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		pub, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = pub
+	}
+	return keys, nil
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k *jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("gothic: unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+	return nil, fmt.Errorf("gothic: unsupported key type %q", k.Kty)
+}
+
+// verifyJWS verifies the signature of the compact JWS token and returns its
+// decoded payload. Keys are looked up with the kid of the header.
+func verifyJWS(token string, key func(kid string) (crypto.PublicKey, error)) ([]byte, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("gothic: malformed jwt")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	b, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(b, &header); err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+
+	var hash crypto.Hash
+	switch header.Alg {
+	case "RS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512", "ES512":
+		hash = crypto.SHA512
+	default:
+		return nil, fmt.Errorf("gothic: unsupported jwt algorithm %q", header.Alg)
+	}
+	pub, err := key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	h := hash.New()
+	h.Write([]byte(parts[0] + "." + parts[1]))
+	digest := h.Sum(nil)
+
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		if header.Alg[0] != 'R' || rsa.VerifyPKCS1v15(pub, hash, digest, sig) != nil {
+			return nil, errors.New("gothic: invalid jwt signature")
+		}
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if header.Alg[0] != 'E' || len(sig) != 2*size ||
+			!ecdsa.Verify(pub, digest, new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])) {
+			return nil, errors.New("gothic: invalid jwt signature")
+		}
+	default:
+		return nil, errors.New("gothic: unsupported jwt key")
+	}
+	return base64.RawURLEncoding.DecodeString(parts[1])
+}
@@ -0,0 +1,51 @@
+package gothic
+
+import (
+	"crypto"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestJWKSCacheSlowEndpoint(t *testing.T) {
+	release := make(chan struct{})
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write([]byte(`{"keys":[]}`))
+	}))
+	defer s.Close()
+	defer close(release)
+
+	known := crypto.PublicKey("known")
+	c := &jwksCache{
+		url:       s.URL,
+		client:    func() *http.Client { return s.Client() },
+		refresh:   time.Hour,
+		keys:      map[string]crypto.PublicKey{"known": known},
+		fetchedAt: time.Now().Add(-2 * time.Hour),
+	}
+	go c.key("unknown")
+	for {
+		c.mu.Lock()
+		fetching := c.fetching != nil
+		c.mu.Unlock()
+		if fetching {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if k, err := c.key("known"); err != nil || k != known {
+			t.Errorf("expected the known key got %v %v", k, err)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the known key is blocked by the fetch in progress")
+	}
+}
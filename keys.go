@@ -0,0 +1,222 @@
+package gothic
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/gorilla/securecookie"
+)
+
+// ErrNoKeys is returned by LoadKeys and Reload when the KeyProvider has no keys.
+var ErrNoKeys = errors.New("gothic: no cookie keys")
+
+// Keys is a pair of keys of securecookie.
+// Encrypt may be empty to only authenticate the cookie.
+type Keys struct {
+	Auth    []byte
+	Encrypt []byte
+}
+
+// Fingerprint returns a short hash identifying the keys, which can be logged
+// without disclosing them.
+func (k Keys) Fingerprint() string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%d:", len(k.Auth))
+	h.Write(k.Auth)
+	h.Write(k.Encrypt)
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+// KeyProvider provides the keys of the flow cookie.
+// The first Keys encodes new cookies and all of them decode cookies, which
+// allows keys to be rotated.
+type KeyProvider interface {
+	Keys() ([]Keys, error)
+}
+
+// DeriveKeys derives a 64 bytes authentication key and a 32 bytes encryption
+// key from secret with HKDF-SHA256.
+func DeriveKeys(secret []byte) Keys {
+	prk := hmacSHA256(make([]byte, sha256.Size), secret)
+	return Keys{
+		Auth:    hkdfExpand(prk, "gothic cookie auth", 64),
+		Encrypt: hkdfExpand(prk, "gothic cookie encrypt", 32),
+	}
+}
+
+func hkdfExpand(prk []byte, info string, n int) []byte {
+	var out, t []byte
+	for i := byte(1); len(out) < n; i++ {
+		t = hmacSHA256(prk, append(append(t, info...), i))
+		out = append(out, t...)
+	}
+	return out[:n]
+}
+
+func hmacSHA256(key, data []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// ParseKey decodes a key written as "hex:..." or "base64:...".
+// Other strings are used as raw bytes.
+func ParseKey(s string) ([]byte, error) {
+	switch {
+	case strings.HasPrefix(s, "hex:"):
+		return hex.DecodeString(s[len("hex:"):])
+	case strings.HasPrefix(s, "base64:"):
+		s = strings.TrimRight(s[len("base64:"):], "=")
+		if strings.ContainsAny(s, "-_") {
+			return base64.RawURLEncoding.DecodeString(s)
+		}
+		return base64.RawStdEncoding.DecodeString(s)
+	}
+	return []byte(s), nil
+}
+
+// EnvKeyProvider reads the keys from the environment.
+//
+// GOTHIC_COOKIE_SECRET is a master secret the keys are derived from by
+// DeriveKeys. Otherwise GOTHIC_COOKIE_AUTH and GOTHIC_COOKIE_ENCRYPT are the
+// keys themselves. Each variable may instead be set as a file name with the
+// _FILE suffix, and its value may be encoded as accepted by ParseKey.
+//
+// The package loads these keys at startup. When they are invalid, the flows
+// using the package level keys fail and Validate reports the error.
+type EnvKeyProvider struct{}
+
+// Keys returns the keys set in the environment, or nil if none is set.
+func (EnvKeyProvider) Keys() ([]Keys, error) {
+	secret, err := envKey("GOTHIC_COOKIE_SECRET")
+	if err != nil {
+		return nil, err
+	}
+	if len(secret) > 0 {
+		return []Keys{DeriveKeys(secret)}, nil
+	}
+
+	var k Keys
+	if k.Auth, err = envKey("GOTHIC_COOKIE_AUTH"); err != nil {
+		return nil, err
+	}
+	if k.Encrypt, err = envKey("GOTHIC_COOKIE_ENCRYPT"); err != nil {
+		return nil, err
+	}
+	if len(k.Auth) == 0 {
+		if len(k.Encrypt) > 0 {
+			return nil, errors.New("gothic: GOTHIC_COOKIE_ENCRYPT is set without GOTHIC_COOKIE_AUTH")
+		}
+		return nil, nil
+	}
+	return []Keys{k}, nil
+}
+
+func envKey(name string) ([]byte, error) {
+	if v := os.Getenv(name); v != "" {
+		return ParseKey(v)
+	}
+	file := os.Getenv(name + "_FILE")
+	if file == "" {
+		return nil, nil
+	}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("gothic: %s_FILE: %v", name, err)
+	}
+	return ParseKey(strings.TrimRight(string(b), "\r\n"))
+}
+
+// FileKeyProvider reads the keys from a JSON file, which can be maintained by
+// a secret manager:
+//
+//	{"keys": [
+//		{"secret": "base64:..."},
+//		{"auth": "hex:...", "encrypt": "hex:..."}
+//	]}
+//
+// The file is read on every call of Keys, so the keys are refreshed by
+// calling LoadKeys again.
+type FileKeyProvider struct {
+	Path string
+}
+
+// Keys returns the keys in the file.
+func (p *FileKeyProvider) Keys() ([]Keys, error) {
+	b, err := ioutil.ReadFile(p.Path)
+	if err != nil {
+		return nil, err
+	}
+	var f struct {
+		Keys []struct {
+			Secret  string `json:"secret"`
+			Auth    string `json:"auth"`
+			Encrypt string `json:"encrypt"`
+		} `json:"keys"`
+	}
+	if err = json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("gothic: %s: %v", p.Path, err)
+	}
+
+	keys := make([]Keys, 0, len(f.Keys))
+	for i, e := range f.Keys {
+		if e.Secret != "" {
+			secret, err := ParseKey(e.Secret)
+			if err != nil {
+				return nil, fmt.Errorf("gothic: %s: key %d: %v", p.Path, i, err)
+			}
+			keys = append(keys, DeriveKeys(secret))
+			continue
+		}
+		var k Keys
+		if k.Auth, err = ParseKey(e.Auth); err == nil {
+			k.Encrypt, err = ParseKey(e.Encrypt)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("gothic: %s: key %d: %v", p.Path, i, err)
+		}
+		if len(k.Auth) == 0 {
+			return nil, fmt.Errorf("gothic: %s: key %d has no auth key", p.Path, i)
+		}
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+// CodecsFromKeys returns the securecookie codecs of keys.
+// The size of the cookie is limited by MaxCookieSize instead of the codecs.
+func CodecsFromKeys(keys []Keys) ([]securecookie.Codec, error) {
+	var pairs [][]byte
+	for _, k := range keys {
+		if len(k.Encrypt) > 0 {
+			switch len(k.Encrypt) {
+			case 16, 24, 32:
+			default:
+				return nil, fmt.Errorf("gothic: encryption key must be 16, 24 or 32 bytes, got %d", len(k.Encrypt))
+			}
+		}
+		pairs = append(pairs, k.Auth, k.Encrypt)
+	}
+	cs := securecookie.CodecsFromPairs(pairs...)
+	for _, c := range cs {
+		c.(*securecookie.SecureCookie).MaxLength(0)
+	}
+	return cs, nil
+}
+
+// LoadKeys replaces the keys of the package level codecs by the keys of p.
+// It is a shorthand for Reload(p, nil).
+func LoadKeys(p KeyProvider) error {
+	if p == nil {
+		return ErrNoKeys
+	}
+	return Reload(p, nil)
+}
@@ -0,0 +1,165 @@
+package gothic
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/securecookie"
+)
+
+func defaultCodecs() []securecookie.Codec {
+	return loadConfig().codecs
+}
+
+func TestParseKey(t *testing.T) {
+	for s, want := range map[string]string{
+		"raw":          "raw",
+		"hex:6b6579":   "key",
+		"base64:a2V5":  "key",
+		"base64:a2V5=": "key",
+		"base64:-_8":   "\xfb\xff",
+	} {
+		got, err := ParseKey(s)
+		if err != nil {
+			t.Errorf("%s: %v", s, err)
+			continue
+		}
+		if string(got) != want {
+			t.Errorf("%s: expected %q got %q", s, want, got)
+		}
+	}
+	if _, err := ParseKey("hex:zz"); err == nil {
+		t.Error("expected an error for invalid hex")
+	}
+}
+
+func TestDeriveKeys(t *testing.T) {
+	k := DeriveKeys([]byte("secret"))
+	if len(k.Auth) != 64 || len(k.Encrypt) != 32 {
+		t.Fatalf("expected 64 and 32 bytes keys got %d and %d", len(k.Auth), len(k.Encrypt))
+	}
+	if !bytes.Equal(k.Auth, DeriveKeys([]byte("secret")).Auth) {
+		t.Error("expected the derivation to be deterministic")
+	}
+	if bytes.Equal(k.Auth, DeriveKeys([]byte("secret2")).Auth) {
+		t.Error("expected different keys for different secrets")
+	}
+	if bytes.Equal(k.Auth[:32], k.Encrypt) {
+		t.Error("expected independent keys")
+	}
+}
+
+func TestEnvKeyProvider(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "secret")
+	if err := ioutil.WriteFile(file, []byte("hex:736563726574\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GOTHIC_COOKIE_SECRET", "")
+	t.Setenv("GOTHIC_COOKIE_SECRET_FILE", file)
+	keys, err := EnvKeyProvider{}.Keys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || !bytes.Equal(keys[0].Auth, DeriveKeys([]byte("secret")).Auth) {
+		t.Errorf("expected the keys derived from the file got %v", keys)
+	}
+
+	t.Setenv("GOTHIC_COOKIE_SECRET_FILE", "")
+	t.Setenv("GOTHIC_COOKIE_AUTH", "auth")
+	t.Setenv("GOTHIC_COOKIE_ENCRYPT", "base64:"+string(bytes.Repeat([]byte("A"), 43)))
+	if keys, err = (EnvKeyProvider{}).Keys(); err != nil {
+		t.Fatal(err)
+	}
+	if string(keys[0].Auth) != "auth" || len(keys[0].Encrypt) != 32 {
+		t.Errorf("expected the keys in the environment got %v", keys)
+	}
+
+	t.Setenv("GOTHIC_COOKIE_AUTH_FILE", filepath.Join(dir, "missing"))
+	t.Setenv("GOTHIC_COOKIE_AUTH", "")
+	if _, err = (EnvKeyProvider{}).Keys(); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
+
+func TestLoadEnvKeysError(t *testing.T) {
+	defer current.Store(loadConfig())
+
+	t.Setenv("GOTHIC_COOKIE_SECRET", "")
+	t.Setenv("GOTHIC_COOKIE_AUTH", "auth")
+	t.Setenv("GOTHIC_COOKIE_ENCRYPT", "short")
+	loadEnvKeys()
+
+	w, r := wr("GET", "/auth/"+providerName, nil)
+	if _, err := GetAuthURL(providerName, w, r); err == nil || !strings.Contains(err.Error(), "encryption key") {
+		t.Errorf("expected the key error got %v", err)
+	}
+	if rep := Validate(ValidateOptions{}); !hasProblem(rep, Error, "cookie keys failed to load") {
+		t.Errorf("expected the key error got %v", rep.Problems)
+	}
+	w, r = wr("GET", "/debug/gothic", nil)
+	DiagnosticsHandler(func(*http.Request) bool { return true }).ServeHTTP(w, r)
+	if !strings.Contains(w.Body.String(), `"key_error"`) {
+		t.Errorf("expected the key error in the diagnostics got %s", w.Body.String())
+	}
+
+	// a reload recovers
+	if err := LoadKeys(staticKeys{DeriveKeys([]byte("secret"))}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetAuthURL(providerName, httptest.NewRecorder(), r); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFileKeyProviderRotation(t *testing.T) {
+	defer current.Store(loadConfig())
+
+	file := filepath.Join(t.TempDir(), "keys.json")
+	write := func(s string) {
+		if err := ioutil.WriteFile(file, []byte(s), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p := &FileKeyProvider{Path: file}
+
+	write(`{"keys": [{"secret": "old"}]}`)
+	if err := LoadKeys(p); err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := securecookie.EncodeMulti(CookieName, "value", defaultCodecs()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	write(`{"keys": [{"auth": "hex:6e6577"}, {"secret": "old"}]}`)
+	if err = LoadKeys(p); err != nil {
+		t.Fatal(err)
+	}
+	var s string
+	if err = securecookie.DecodeMulti(CookieName, encoded, &s, defaultCodecs()...); err != nil {
+		t.Errorf("expected the old key to decode: %v", err)
+	}
+
+	write(`{"keys": [{"auth": "hex:6e6577"}]}`)
+	if err = LoadKeys(p); err != nil {
+		t.Fatal(err)
+	}
+	if err = securecookie.DecodeMulti(CookieName, encoded, &s, defaultCodecs()...); err == nil {
+		t.Error("expected the removed key not to decode")
+	}
+
+	write(`{"keys": []}`)
+	if err = LoadKeys(p); err != ErrNoKeys {
+		t.Errorf("expected %v got %v", ErrNoKeys, err)
+	}
+	write(`{"keys": [{"auth": "a", "encrypt": "short"}]}`)
+	if err = LoadKeys(p); err == nil {
+		t.Error("expected an error for an invalid encryption key")
+	}
+}
@@ -0,0 +1,145 @@
+package gothic
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimiter limits the flows of Gothic instances without RateLimiter.
+// Flows are not limited when nil.
+var RateLimiter Limiter
+
+// Limiter limits events by key. Gothic uses keys made of the kind of the
+// event, the provider and the client address: a flow start takes a token,
+// and a callback is refused while no token is left and takes one when it
+// fails.
+type Limiter interface {
+	// Allow takes a token of key. It reports false with the time until a
+	// token is available when none is left.
+	Allow(key string) (ok bool, retryAfter time.Duration)
+	// Delay returns the time until key has a token without taking it.
+	Delay(key string) time.Duration
+}
+
+// RateLimitError is returned by GetAuthURL and CompleteAuth when the client
+// is limited.
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return "gothic: too many requests, retry after " + e.RetryAfter.String()
+}
+
+// retryAfter returns the value of the Retry-After header in whole seconds.
+func (e *RateLimitError) retryAfter() string {
+	s := int64((e.RetryAfter + time.Second - 1) / time.Second)
+	if s < 1 {
+		s = 1
+	}
+	return strconv.FormatInt(s, 10)
+}
+
+// TokenBucket is an in-memory Limiter which gives each key Burst tokens
+// refilled at one token per Every.
+type TokenBucket struct {
+	Every time.Duration
+	Burst int
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	pruned  time.Time
+	now     func() time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	at     time.Time
+}
+
+// NewTokenBucket returns a TokenBucket with burst tokens refilled at one
+// token per every.
+func NewTokenBucket(every time.Duration, burst int) *TokenBucket {
+	return &TokenBucket{Every: every, Burst: burst}
+}
+
+// Allow implements Limiter.
+func (tb *TokenBucket) Allow(key string) (bool, time.Duration) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	b, now := tb.bucket(key)
+	if b.tokens < 1 {
+		return false, tb.delay(b)
+	}
+	b.tokens--
+	b.at = now
+	return true, 0
+}
+
+// Delay implements Limiter.
+func (tb *TokenBucket) Delay(key string) time.Duration {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	b, _ := tb.bucket(key)
+	return tb.delay(b)
+}
+
+func (tb *TokenBucket) delay(b *tokenBucket) time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) * float64(tb.Every))
+}
+
+// bucket returns the bucket of key refilled until now.
+func (tb *TokenBucket) bucket(key string) (*tokenBucket, time.Time) {
+	now := time.Now()
+	if tb.now != nil {
+		now = tb.now()
+	}
+	full := float64(tb.Burst)
+	if tb.buckets == nil {
+		tb.buckets = map[string]*tokenBucket{}
+	}
+	// full buckets are forgotten to bound the memory
+	if fill := time.Duration(tb.Burst) * tb.Every; now.Sub(tb.pruned) >= fill {
+		for k, b := range tb.buckets {
+			if now.Sub(b.at) >= fill {
+				delete(tb.buckets, k)
+			}
+		}
+		tb.pruned = now
+	}
+
+	b := tb.buckets[key]
+	if b == nil {
+		b = &tokenBucket{tokens: full, at: now}
+		tb.buckets[key] = b
+		return b, now
+	}
+	if tb.Every > 0 {
+		b.tokens += float64(now.Sub(b.at)) / float64(tb.Every)
+	} else {
+		b.tokens = full
+	}
+	if b.tokens > full {
+		b.tokens = full
+	}
+	b.at = now
+	return b, now
+}
+
+func (g *Gothic) rateLimiter() Limiter {
+	if g.RateLimiter != nil {
+		return g.RateLimiter
+	}
+	return RateLimiter
+}
+
+// limitKey identifies the client of r by its address taken from the
+// forwarding headers of the trusted proxies, see Proxies.
+func (g *Gothic) limitKey(kind string, r *http.Request, providerName string) string {
+	return kind + "\x00" + providerName + "\x00" + g.proxies().clientAddr(r)
+}
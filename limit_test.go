@@ -0,0 +1,123 @@
+package gothic
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Unix(0, 0)
+	tb := NewTokenBucket(time.Second, 2)
+	tb.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := tb.Allow("a"); !ok {
+			t.Fatalf("expected token %d to be allowed", i)
+		}
+	}
+	if ok, d := tb.Allow("a"); ok || d != time.Second {
+		t.Errorf("expected to wait %v got %v %v", time.Second, ok, d)
+	}
+	if ok, _ := tb.Allow("b"); !ok {
+		t.Error("expected keys to be independent")
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if d := tb.Delay("a"); d != 500*time.Millisecond {
+		t.Errorf("expected to wait %v got %v", 500*time.Millisecond, d)
+	}
+	now = now.Add(500 * time.Millisecond)
+	if ok, _ := tb.Allow("a"); !ok {
+		t.Error("expected a refilled token")
+	}
+
+	// full buckets are forgotten
+	now = now.Add(time.Hour)
+	tb.Delay("c")
+	if len(tb.buckets) != 1 {
+		t.Errorf("expected 1 bucket got %d", len(tb.buckets))
+	}
+}
+
+func TestHandlerRateLimit(t *testing.T) {
+	h := newTestHandler(NoRecovery)
+	h.Gothic = &Gothic{RateLimiter: NewTokenBucket(time.Hour, 1)}
+
+	w, r := wr("GET", "/auth/"+providerName, nil)
+	r.RemoteAddr = "198.51.100.1:1"
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusTemporaryRedirect {
+		t.Fatalf("expected %d got %d", http.StatusTemporaryRedirect, w.Code)
+	}
+	w, r = wr("GET", "/auth/"+providerName, nil)
+	r.RemoteAddr = "198.51.100.1:2"
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "3600" {
+		t.Errorf("expected %d with Retry-After got %d %q", http.StatusTooManyRequests, w.Code, w.Header().Get("Retry-After"))
+	}
+
+	// callbacks are limited after failing
+	for i, want := range []int{http.StatusInternalServerError, http.StatusTooManyRequests} {
+		w, r = wr("GET", "/auth/"+providerName+"/callback", nil)
+		r.RemoteAddr = "198.51.100.1:1"
+		h.ServeHTTP(w, r)
+		if w.Code != want {
+			t.Errorf("%d: expected %d got %d", i, want, w.Code)
+		}
+	}
+	w, r = wr("GET", "/auth/"+providerName+"/callback", nil)
+	r.RemoteAddr = "198.51.100.2:1"
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expected other clients not to be limited got %d", w.Code)
+	}
+}
+
+func TestRateLimitBehindProxy(t *testing.T) {
+	ps, _ := ParseProxies("192.0.2.0/24")
+	g := &Gothic{RateLimiter: NewTokenBucket(time.Hour, 1), TrustedProxies: ps}
+	begin := func(header, value string) error {
+		w, r := wr("GET", "/auth/"+providerName, nil)
+		r.RemoteAddr = "192.0.2.1:1"
+		r.Header.Set(header, value)
+		return g.BeginAuth(providerName, w, r)
+	}
+
+	if err := begin("X-Forwarded-For", "198.51.100.1"); err != nil {
+		t.Fatal(err)
+	}
+	// a forged leading entry does not get a fresh bucket
+	var le *RateLimitError
+	if err := begin("X-Forwarded-For", "203.0.113.1, 198.51.100.1"); !errors.As(err, &le) {
+		t.Errorf("expected a RateLimitError got %v", err)
+	}
+	if err := begin("X-Forwarded-For", "198.51.100.2"); err != nil {
+		t.Errorf("expected another client not to be limited got %v", err)
+	}
+
+	// obfuscated identifiers are chosen by the client unless the proxies
+	// replace them
+	g.RateLimiter = NewTokenBucket(time.Hour, 1)
+	g.TrustedProxies.Header = ForwardedHeader
+	for i := 0; i < 3; i++ {
+		err := begin("Forwarded", "for=_n"+strconv.Itoa(i))
+		if i == 0 && err != nil {
+			t.Fatal(err)
+		}
+		if i > 0 && !errors.As(err, &le) {
+			t.Errorf("%d: expected a RateLimitError got %v", i, err)
+		}
+	}
+
+	g.RateLimiter = NewTokenBucket(time.Hour, 1)
+	g.TrustedProxies.ObfuscatedIdentifiers = true
+	if err := begin("Forwarded", "for=_client1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := begin("Forwarded", "for=_client2"); err != nil {
+		t.Errorf("expected another client not to be limited got %v", err)
+	}
+}
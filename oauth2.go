@@ -0,0 +1,311 @@
+package gothic
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/markbates/goth"
+)
+
+// AuthStyle is how an OAuth2Provider authenticates to the token endpoint.
+type AuthStyle int
+
+const (
+	// AuthStyleHeader sends the client credentials in the Authorization header.
+	AuthStyleHeader AuthStyle = iota
+	// AuthStyleParams sends the client credentials in the request body.
+	AuthStyleParams
+)
+
+// OAuth2Config is the configuration of an OAuth2Provider.
+type OAuth2Config struct {
+	ClientID     string
+	ClientSecret string
+	AuthURL      string
+	TokenURL     string
+	UserInfoURL  string
+	CallbackURL  string
+	Scopes       []string
+	AuthStyle    AuthStyle
+	// AuthParams are added to the authorization URL.
+	AuthParams url.Values
+	// Claims maps the userinfo response to goth.User.
+	Claims ClaimMapping
+	// HTTPClient is used to access the server. DefaultHTTPClient is used when nil.
+	HTTPClient *http.Client
+}
+
+// OAuth2Provider is a goth.Provider for any OAuth 2.0 server, configured
+// with its endpoints and a ClaimMapping instead of code.
+type OAuth2Provider struct {
+	OAuth2Config
+	name string
+}
+
+// NewOAuth2Provider returns a new OAuth2Provider named name.
+func NewOAuth2Provider(name string, config OAuth2Config) *OAuth2Provider {
+	return &OAuth2Provider{OAuth2Config: config, name: name}
+}
+
+// Name is the name used to retrieve this provider later.
+func (p *OAuth2Provider) Name() string {
+	return p.name
+}
+
+// Debug is a no-op for this provider.
+func (p *OAuth2Provider) Debug(debug bool) {}
+
+// Protocol reports that the provider speaks OAuth 2.0.
+func (p *OAuth2Provider) Protocol() Protocol {
+	return OAuth2
+}
+
+// BeginAuth asks the server for an authentication end-point.
+func (p *OAuth2Provider) BeginAuth(state string) (goth.Session, error) {
+	return p.BeginAuthWithCallback(state, "")
+}
+
+// BeginAuthWithCallback is like BeginAuth but uses callbackURL when the
+// provider has no CallbackURL configured.
+func (p *OAuth2Provider) BeginAuthWithCallback(state, callbackURL string) (goth.Session, error) {
+	s, err := p.beginAuth(state, p.callbackURL(callbackURL))
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (p *OAuth2Provider) callbackURL(callbackURL string) string {
+	if p.CallbackURL != "" {
+		return p.CallbackURL
+	}
+	return callbackURL
+}
+
+func (p *OAuth2Provider) beginAuth(state, callbackURL string) (*OAuth2Session, error) {
+	u, err := url.Parse(p.AuthURL)
+	if err != nil {
+		return nil, err
+	}
+	v := u.Query()
+	for k, vs := range p.AuthParams {
+		v[k] = vs
+	}
+	v.Set("client_id", p.ClientID)
+	v.Set("redirect_uri", callbackURL)
+	v.Set("response_type", "code")
+	if len(p.Scopes) > 0 {
+		v.Set("scope", strings.Join(p.Scopes, " "))
+	}
+	v.Set("state", state)
+	u.RawQuery = v.Encode()
+	s := &OAuth2Session{AuthURL: u.String()}
+	if callbackURL != p.CallbackURL {
+		s.CallbackURL = callbackURL
+	}
+	return s, nil
+}
+
+// UnmarshalSession will unmarshal a JSON string into a session.
+func (p *OAuth2Provider) UnmarshalSession(data string) (goth.Session, error) {
+	s := &OAuth2Session{}
+	return s, json.NewDecoder(strings.NewReader(data)).Decode(s)
+}
+
+// FetchUser will go to the userinfo end-point and access basic information about the user.
+func (p *OAuth2Provider) FetchUser(session goth.Session) (goth.User, error) {
+	s := session.(*OAuth2Session)
+	user := goth.User{
+		Provider:    p.Name(),
+		AccessToken: s.AccessToken,
+	}
+	if s.AccessToken == "" {
+		return user, errors.New("gothic: session has no access token")
+	}
+	claims, err := p.userInfo(s.AccessToken)
+	if err != nil {
+		return user, err
+	}
+	p.Claims.Apply(claims, &user)
+	return user, nil
+}
+
+func (p *OAuth2Provider) userInfo(accessToken string) (map[string]interface{}, error) {
+	if p.UserInfoURL == "" {
+		return nil, fmt.Errorf("gothic: %s has no userinfo URL", p.Name())
+	}
+
+	req, err := http.NewRequest("GET", p.UserInfoURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError("userinfo", resp)
+	}
+
+	var claims map[string]interface{}
+	d := json.NewDecoder(resp.Body)
+	d.UseNumber()
+	if err = d.Decode(&claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// DefaultHTTPClient accesses the servers of providers without HTTPClient.
+// Unlike http.DefaultClient it has a timeout, so an unresponsive server does
+// not block flows forever.
+var DefaultHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+func (p *OAuth2Provider) client() *http.Client {
+	if p.HTTPClient != nil {
+		return p.HTTPClient
+	}
+	return DefaultHTTPClient
+}
+
+// exchange exchanges the authorization code in params at the token endpoint.
+// callbackURL is the redirect_uri of the authorization request.
+func (p *OAuth2Provider) exchange(params goth.Params, callbackURL string) (*oauth2Token, error) {
+	if e := params.Get("error"); e != "" {
+		return nil, fmt.Errorf("gothic: authorization failed: %s %s", e, params.Get("error_description"))
+	}
+	code := params.Get("code")
+	if code == "" {
+		return nil, errors.New("gothic: callback has no authorization code")
+	}
+
+	v := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {p.callbackURL(callbackURL)},
+	}
+	if p.AuthStyle == AuthStyleParams {
+		v.Set("client_id", p.ClientID)
+		v.Set("client_secret", p.ClientSecret)
+	}
+	req, err := http.NewRequest("POST", p.TokenURL, strings.NewReader(v.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.AuthStyle == AuthStyleHeader {
+		req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+
+	resp, err := p.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError("token", resp)
+	}
+
+	t := &oauth2Token{}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if ct, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); ct == "application/x-www-form-urlencoded" || ct == "text/plain" {
+		// some servers such as GitHub reply in form encoding by default
+		v, err := url.ParseQuery(string(body))
+		if err != nil {
+			return nil, err
+		}
+		t.AccessToken = v.Get("access_token")
+		t.RefreshToken = v.Get("refresh_token")
+		t.IDToken = v.Get("id_token")
+		fmt.Sscan(v.Get("expires_in"), &t.ExpiresIn)
+	} else if err = json.Unmarshal(body, t); err != nil {
+		return nil, err
+	}
+	if t.AccessToken == "" {
+		return nil, errors.New("gothic: token endpoint returned no access token")
+	}
+	return t, nil
+}
+
+type oauth2Token struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	IDToken      string `json:"id_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+func responseError(endpoint string, resp *http.Response) error {
+	var e struct {
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+	}
+	json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&e)
+	if e.Error == "" {
+		return fmt.Errorf("gothic: %s endpoint returned %s", endpoint, resp.Status)
+	}
+	return fmt.Errorf("gothic: %s endpoint returned %s: %s %s", endpoint, resp.Status, e.Error, e.Description)
+}
+
+// OAuth2Session stores data during the auth process with an OAuth2Provider.
+type OAuth2Session struct {
+	AuthURL string
+	// CallbackURL is the callback URL of the flow when the provider has none
+	// configured.
+	CallbackURL  string `json:",omitempty"`
+	AccessToken  string `json:",omitempty"`
+	RefreshToken string `json:",omitempty"`
+	IDToken      string `json:",omitempty"`
+	Expiry       time.Time
+}
+
+// GetAuthURL will return the URL set by calling the `BeginAuth` function on the provider.
+func (s *OAuth2Session) GetAuthURL() (string, error) {
+	if s.AuthURL == "" {
+		return "", errors.New("gothic: missing AuthURL")
+	}
+	return s.AuthURL, nil
+}
+
+// Marshal the session into a string.
+func (s *OAuth2Session) Marshal() string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+// Authorize the session with the server and return the access token to be stored for future use.
+// Authorizing an authorized session returns its access token again.
+func (s *OAuth2Session) Authorize(provider goth.Provider, params goth.Params) (string, error) {
+	if s.AccessToken != "" {
+		return s.AccessToken, nil
+	}
+	p, ok := provider.(*OAuth2Provider)
+	if !ok {
+		return "", fmt.Errorf("gothic: OAuth2Session cannot be authorized by %T", provider)
+	}
+	t, err := p.exchange(params, s.CallbackURL)
+	if err != nil {
+		return "", err
+	}
+	s.AccessToken = t.AccessToken
+	s.RefreshToken = t.RefreshToken
+	s.IDToken = t.IDToken
+	if t.ExpiresIn > 0 {
+		s.Expiry = time.Now().Add(time.Duration(t.ExpiresIn) * time.Second).UTC().Truncate(time.Second)
+	}
+	return s.AccessToken, nil
+}
@@ -0,0 +1,156 @@
+package gothic_test
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/markbates/goth"
+	"github.com/oov/gothic"
+	"github.com/oov/gothic/gothictest"
+)
+
+// login drives a whole flow of the provider registered in g as name against s.
+func login(g *gothic.Gothic, s *gothictest.Server, name string) (goth.User, error) {
+	w, r := httptest.NewRecorder(), httptest.NewRequest("GET", "/auth/"+name, nil)
+	authURL, err := g.GetAuthURL(name, w, r)
+	if err != nil {
+		return goth.User{}, err
+	}
+	callback, err := s.Authorize(authURL)
+	if err != nil {
+		return goth.User{}, err
+	}
+	r = httptest.NewRequest("GET", callback.String(), nil)
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	return g.CompleteAuth(name, httptest.NewRecorder(), r)
+}
+
+func TestOAuth2ProviderConformance(t *testing.T) {
+	gothictest.TestProvider(t, func(s *gothictest.Server, callbackURL string) goth.Provider {
+		c := s.OAuth2Config(callbackURL)
+		c.AuthStyle = gothic.AuthStyleParams
+		return gothic.NewOAuth2Provider("generic", c)
+	})
+}
+
+func TestOAuth2ProviderClaimMapping(t *testing.T) {
+	s := gothictest.NewServer()
+	defer s.Close()
+	s.AddUser(gothictest.User{
+		ID: "u1",
+		Claims: map[string]interface{}{
+			"data": map[string]interface{}{
+				"id":     42,
+				"login":  "octocat",
+				"emails": []string{"octocat@example.com"},
+			},
+		},
+	})
+
+	c := s.OAuth2Config("http://app.example.com/callback")
+	c.Claims = gothic.ClaimMapping{
+		UserID:   "/data/id",
+		NickName: "data.login",
+		Email:    "data.emails.0",
+		RawData:  map[string]string{"login": "data.login"},
+	}
+	g := &gothic.Gothic{Providers: gothic.NewRegistry(gothic.NewOAuth2Provider("internal", c))}
+
+	user, err := login(g, s, "internal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Provider != "internal" {
+		t.Errorf("expected user.Provider value %q got %q", "internal", user.Provider)
+	}
+	if user.UserID != "42" {
+		t.Errorf("expected user.UserID value %q got %q", "42", user.UserID)
+	}
+	if user.NickName != "octocat" {
+		t.Errorf("expected user.NickName value %q got %q", "octocat", user.NickName)
+	}
+	if user.Email != "octocat@example.com" {
+		t.Errorf("expected user.Email value %q got %q", "octocat@example.com", user.Email)
+	}
+	if len(user.RawData) != 1 || user.RawData["login"] != "octocat" {
+		t.Errorf("unexpected user.RawData %v", user.RawData)
+	}
+}
+
+func TestOAuth2ProviderInvalidClient(t *testing.T) {
+	s := gothictest.NewServer()
+	defer s.Close()
+	s.AddUser(gothictest.User{ID: "u1"})
+
+	c := s.OAuth2Config("http://app.example.com/callback")
+	c.ClientSecret = "wrong"
+	g := &gothic.Gothic{Providers: gothic.NewRegistry(gothic.NewOAuth2Provider("internal", c))}
+	_, err := login(g, s, "internal")
+	if err == nil {
+		t.Fatal("expected error got none")
+	}
+	msg := "gothic: token endpoint returned 401 Unauthorized: invalid_client "
+	if err.Error() != msg {
+		t.Errorf("expected %q got %q", msg, err)
+	}
+}
+
+func TestOAuth2ProviderDynamicCallback(t *testing.T) {
+	s := gothictest.NewServer()
+	defer s.Close()
+	s.AddUser(gothictest.User{ID: "u1", Email: "u1@example.com"})
+	s.Login("u1")
+
+	oidc, err := s.OIDCProvider("oidc", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := &gothic.Gothic{Providers: gothic.NewRegistry(
+		s.Provider("generic", ""),
+		oidc,
+		gothic.Alias("alias", s.Provider("generic", "")),
+	)}
+	for _, name := range []string{"generic", "oidc", "alias"} {
+		w, r := httptest.NewRecorder(), httptest.NewRequest("GET", "/auth/"+name, nil)
+		authURL, err := g.GetAuthURL(name, w, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		callback, err := s.Authorize(authURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "http://example.com/auth/" + name + "/callback"; !strings.HasPrefix(callback.String(), want+"?") {
+			t.Errorf("expected the callback %s got %s", want, callback)
+		}
+
+		r = httptest.NewRequest("GET", callback.String(), nil)
+		for _, c := range w.Result().Cookies() {
+			r.AddCookie(c)
+		}
+		user, err := g.CompleteAuth(name, httptest.NewRecorder(), r)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if user.Email != "u1@example.com" {
+			t.Errorf("%s: expected the user got %+v", name, user)
+		}
+	}
+}
+
+// wrappedProvider is a provider which is not an *OAuth2Provider.
+type wrappedProvider struct {
+	goth.Provider
+}
+
+func TestOAuth2SessionOtherProvider(t *testing.T) {
+	if _, err := (&gothic.OAuth2Session{}).Authorize(wrappedProvider{}, nil); err == nil {
+		t.Error("expected an error for another provider")
+	}
+	if _, err := (&gothic.OIDCSession{}).Authorize(wrappedProvider{}, nil); err == nil {
+		t.Error("expected an error for another provider")
+	}
+}
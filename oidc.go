@@ -0,0 +1,249 @@
+package gothic
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/markbates/goth"
+)
+
+// OIDCProvider is a goth.Provider for any OpenID Connect provider.
+// It is configured by the discovery document of the issuer and verifies the
+// ID token of every flow.
+type OIDCProvider struct {
+	*OAuth2Provider
+	Issuer string
+	// ClockSkew is the tolerance of the expiry of ID tokens.
+	ClockSkew time.Duration
+
+	jwks *jwksCache
+}
+
+// NewOIDCProvider fetches the discovery document of issuer and returns a
+// provider named name. The "openid" scope is always requested.
+func NewOIDCProvider(name, issuer, clientID, clientSecret, callbackURL string, scopes ...string) (*OIDCProvider, error) {
+	return NewOIDCProviderWithClient(DefaultHTTPClient, name, issuer, clientID, clientSecret, callbackURL, scopes...)
+}
+
+// NewOIDCProviderWithClient is like NewOIDCProvider but accesses the issuer
+// with client.
+func NewOIDCProviderWithClient(client *http.Client, name, issuer, clientID, clientSecret, callbackURL string, scopes ...string) (*OIDCProvider, error) {
+	issuer = strings.TrimRight(issuer, "/")
+	resp, err := client.Get(issuer + "/.well-known/openid-configuration")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError("discovery", resp)
+	}
+
+	var d struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		UserInfoEndpoint      string `json:"userinfo_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&d); err != nil {
+		return nil, err
+	}
+	if d.Issuer != issuer {
+		return nil, fmt.Errorf("gothic: discovery document of %q is issued by %q", issuer, d.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, fmt.Errorf("gothic: discovery document of %q lacks required endpoints", issuer)
+	}
+
+	if len(scopes) == 0 {
+		scopes = []string{"email", "profile"}
+	}
+	p := &OIDCProvider{
+		OAuth2Provider: NewOAuth2Provider(name, OAuth2Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			AuthURL:      d.AuthorizationEndpoint,
+			TokenURL:     d.TokenEndpoint,
+			UserInfoURL:  d.UserInfoEndpoint,
+			CallbackURL:  callbackURL,
+			Scopes:       append([]string{"openid"}, scopes...),
+			Claims:       StandardClaims,
+			HTTPClient:   client,
+		}),
+		Issuer:    d.Issuer,
+		ClockSkew: time.Minute,
+	}
+	p.jwks = &jwksCache{url: d.JWKSURI, client: p.client, refresh: time.Hour}
+	return p, nil
+}
+
+// BeginAuth asks the issuer for an authentication end-point.
+func (p *OIDCProvider) BeginAuth(state string) (goth.Session, error) {
+	return p.BeginAuthWithCallback(state, "")
+}
+
+// BeginAuthWithCallback is like BeginAuth but uses callbackURL when the
+// provider has no CallbackURL configured.
+func (p *OIDCProvider) BeginAuthWithCallback(state, callbackURL string) (goth.Session, error) {
+	s, err := p.beginAuth(state, p.callbackURL(callbackURL))
+	if err != nil {
+		return nil, err
+	}
+	nonce := base64.RawURLEncoding.EncodeToString(securecookie.GenerateRandomKey(16))
+	s.AuthURL += "&nonce=" + nonce
+	return &OIDCSession{OAuth2Session: *s, Nonce: nonce}, nil
+}
+
+// UnmarshalSession will unmarshal a JSON string into a session.
+func (p *OIDCProvider) UnmarshalSession(data string) (goth.Session, error) {
+	s := &OIDCSession{}
+	return s, json.NewDecoder(strings.NewReader(data)).Decode(s)
+}
+
+// FetchUser returns the user identified by the verified ID token, completed
+// by the userinfo end-point when the issuer has one.
+func (p *OIDCProvider) FetchUser(session goth.Session) (goth.User, error) {
+	s := session.(*OIDCSession)
+	user := goth.User{
+		Provider:    p.Name(),
+		AccessToken: s.AccessToken,
+	}
+	if s.AccessToken == "" || s.IDToken == "" {
+		return user, errors.New("gothic: session has no access token")
+	}
+	claims, err := p.VerifyIDToken(s.IDToken, s.Nonce)
+	if err != nil {
+		return user, err
+	}
+
+	if p.UserInfoURL != "" {
+		info, err := p.userInfo(s.AccessToken)
+		if err != nil {
+			return user, err
+		}
+		if sub, _ := info["sub"].(string); sub != claims["sub"] {
+			return user, errors.New("gothic: userinfo subject does not match the id token")
+		}
+		for k, v := range info {
+			if _, ok := claims[k]; !ok {
+				claims[k] = v
+			}
+		}
+	}
+	p.Claims.Apply(claims, &user)
+	return user, nil
+}
+
+// VerifyIDToken verifies the signature, issuer, audience, authorized party,
+// expiry and nonce of the ID token and returns its claims.
+// An empty nonce skips the nonce check.
+func (p *OIDCProvider) VerifyIDToken(token, nonce string) (map[string]interface{}, error) {
+	payload, err := verifyJWS(token, p.jwks.key)
+	if err != nil {
+		return nil, err
+	}
+	var claims map[string]interface{}
+	d := json.NewDecoder(strings.NewReader(string(payload)))
+	d.UseNumber()
+	if err = d.Decode(&claims); err != nil {
+		return nil, err
+	}
+
+	if iss, _ := claims["iss"].(string); iss != p.Issuer {
+		return nil, fmt.Errorf("gothic: id token issued by %q", iss)
+	}
+
+	var aud []string
+	switch v := claims["aud"].(type) {
+	case string:
+		aud = []string{v}
+	case []interface{}:
+		for _, a := range v {
+			if s, ok := a.(string); ok {
+				aud = append(aud, s)
+			}
+		}
+	}
+	found := false
+	for _, a := range aud {
+		found = found || a == p.ClientID
+	}
+	if !found {
+		return nil, errors.New("gothic: id token is not issued for this client")
+	}
+	azp, hasAzp := claims["azp"].(string)
+	if (hasAzp || len(aud) > 1) && azp != p.ClientID {
+		return nil, errors.New("gothic: id token is authorized for another party")
+	}
+
+	exp, err := numericDate(claims["exp"])
+	if err != nil {
+		return nil, errors.New("gothic: id token has no valid exp")
+	}
+	if time.Now().After(exp.Add(p.ClockSkew)) {
+		return nil, errors.New("gothic: id token has expired")
+	}
+
+	if nonce != "" && claims["nonce"] != nonce {
+		return nil, errors.New("gothic: id token nonce does not match")
+	}
+	return claims, nil
+}
+
+func numericDate(v interface{}) (time.Time, error) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return time.Time{}, errors.New("not a number")
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(int64(f), 0), nil
+}
+
+// OIDCSession stores data during the auth process with an OIDCProvider.
+type OIDCSession struct {
+	OAuth2Session
+	Nonce string
+}
+
+// Marshal the session into a string.
+func (s *OIDCSession) Marshal() string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+// Authorize the session with the issuer and return the access token to be stored for future use.
+func (s *OIDCSession) Authorize(provider goth.Provider, params goth.Params) (string, error) {
+	if s.AccessToken != "" {
+		return s.AccessToken, nil
+	}
+	p, ok := provider.(*OIDCProvider)
+	if !ok {
+		return "", fmt.Errorf("gothic: OIDCSession cannot be authorized by %T", provider)
+	}
+	t, err := p.exchange(params, s.CallbackURL)
+	if err != nil {
+		return "", err
+	}
+	if t.IDToken == "" {
+		return "", errors.New("gothic: token endpoint returned no id token")
+	}
+	if _, err = p.VerifyIDToken(t.IDToken, s.Nonce); err != nil {
+		return "", err
+	}
+	s.AccessToken = t.AccessToken
+	s.RefreshToken = t.RefreshToken
+	s.IDToken = t.IDToken
+	if t.ExpiresIn > 0 {
+		s.Expiry = time.Now().Add(time.Duration(t.ExpiresIn) * time.Second).UTC().Truncate(time.Second)
+	}
+	return s.AccessToken, nil
+}
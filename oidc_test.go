@@ -0,0 +1,143 @@
+package gothic_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/markbates/goth"
+	"github.com/oov/gothic"
+	"github.com/oov/gothic/gothictest"
+)
+
+func newOIDC(t *testing.T) (*gothictest.Server, *gothic.Gothic) {
+	s := gothictest.NewServer()
+	s.AddUser(gothictest.User{
+		ID:            "alice",
+		Email:         "alice@example.com",
+		EmailVerified: true,
+		Name:          "Alice",
+		Claims:        map[string]interface{}{"hd": "example.com"},
+	})
+	p, err := s.OIDCProvider("oidc", "http://app.example.com/auth/oidc/callback")
+	if err != nil {
+		s.Close()
+		t.Fatal(err)
+	}
+	return s, &gothic.Gothic{Providers: gothic.NewRegistry(p)}
+}
+
+func TestOIDCProviderConformance(t *testing.T) {
+	gothictest.TestProvider(t, func(s *gothictest.Server, callbackURL string) goth.Provider {
+		p, err := s.OIDCProvider("oidc", callbackURL)
+		if err != nil {
+			panic(err)
+		}
+		return p
+	})
+}
+
+func TestOIDCProvider(t *testing.T) {
+	s, g := newOIDC(t)
+	defer s.Close()
+
+	for i := 0; i < 2; i++ {
+		user, err := login(g, s, "oidc")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.Provider != "oidc" || user.UserID != "alice" || user.Email != "alice@example.com" || user.Name != "Alice" {
+			t.Errorf("unexpected user %+v", user)
+		}
+		if user.RawData["hd"] != "example.com" {
+			t.Errorf("expected hd claim in RawData got %v", user.RawData)
+		}
+	}
+	if n := s.Requests(gothictest.JWKS); n != 1 {
+		t.Errorf("expected the keys to be cached got %d JWKS requests", n)
+	}
+}
+
+func TestOIDCProviderKeyRotation(t *testing.T) {
+	defer func(d time.Duration) { *gothic.MinJWKSRefresh = d }(*gothic.MinJWKSRefresh)
+	*gothic.MinJWKSRefresh = 0
+
+	s, g := newOIDC(t)
+	defer s.Close()
+
+	if _, err := login(g, s, "oidc"); err != nil {
+		t.Fatal(err)
+	}
+	s.RotateKey()
+	if _, err := login(g, s, "oidc"); err != nil {
+		t.Fatal(err)
+	}
+	if n := s.Requests(gothictest.JWKS); n != 2 {
+		t.Errorf("expected the keys to be refreshed once got %d JWKS requests", n)
+	}
+}
+
+func TestOIDCProviderInvalidIDToken(t *testing.T) {
+	s, g := newOIDC(t)
+	defer s.Close()
+
+	modify := func(k string, v interface{}) func(map[string]interface{}) (string, error) {
+		return func(claims map[string]interface{}) (string, error) {
+			claims[k] = v
+			return s.SignIDToken(claims)
+		}
+	}
+	tests := map[string]struct {
+		idToken func(map[string]interface{}) (string, error)
+		msg     string
+	}{
+		"iss": {modify("iss", "https://evil.example.com"), `gothic: id token issued by "https://evil.example.com"`},
+		"aud": {modify("aud", "other"), "gothic: id token is not issued for this client"},
+		"azp": {modify("azp", "other"), "gothic: id token is authorized for another party"},
+		"multi": {func(claims map[string]interface{}) (string, error) {
+			claims["aud"] = []string{s.ClientID, "other"}
+			delete(claims, "azp")
+			return s.SignIDToken(claims)
+		}, "gothic: id token is authorized for another party"},
+		"exp":   {modify("exp", time.Now().Add(-time.Hour).Unix()), "gothic: id token has expired"},
+		"nonce": {modify("nonce", "replayed"), "gothic: id token nonce does not match"},
+		"signature": {func(claims map[string]interface{}) (string, error) {
+			token, err := s.SignIDToken(claims)
+			if err != nil {
+				return "", err
+			}
+			claims["sub"] = "mallory"
+			forged, err := s.SignIDToken(claims)
+			if err != nil {
+				return "", err
+			}
+			parts, forgedParts := strings.Split(token, "."), strings.Split(forged, ".")
+			return parts[0] + "." + forgedParts[1] + "." + parts[2], nil
+		}, "gothic: invalid jwt signature"},
+	}
+	for name, tt := range tests {
+		s.IDToken = tt.idToken
+		_, err := login(g, s, "oidc")
+		if err == nil {
+			t.Errorf("%s: expected error got none", name)
+			continue
+		}
+		if err.Error() != tt.msg {
+			t.Errorf("%s: expected %q got %q", name, tt.msg, err)
+		}
+	}
+}
+
+func TestOIDCProviderDiscoveryFailure(t *testing.T) {
+	s := gothictest.NewServer()
+	defer s.Close()
+
+	s.FailNext(gothictest.Discovery, gothictest.Failure{Status: http.StatusNotFound})
+	if _, err := s.OIDCProvider("oidc", "http://app.example.com/callback"); err == nil {
+		t.Error("expected error got none")
+	}
+	if _, err := gothic.NewOIDCProviderWithClient(s.Client(), "oidc", s.URL+"/other", s.ClientID, s.ClientSecret, "http://app.example.com/callback"); err == nil {
+		t.Error("expected error got none")
+	}
+}
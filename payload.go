@@ -0,0 +1,111 @@
+package gothic
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// CompressPayload enables compression of the flow cookie when it makes the
+// cookie smaller.
+var CompressPayload bool
+
+// AcceptLegacyPayload makes CompleteAuth accept flow cookies of the legacy
+// format, so the flows started before upgrading complete. Such cookies record
+// neither the provider, the tenant nor the browser binding, so they bypass
+// those checks. Clear it once the legacy cookies have expired.
+var AcceptLegacyPayload = true
+
+// ErrLegacyPayload is wrapped in the *FlowCookieError returned by
+// CompleteAuth for flow cookies of the legacy format when they are not
+// accepted.
+var ErrLegacyPayload = errors.New("gothic: flow cookie of the legacy format is not accepted")
+
+func (g *Gothic) acceptLegacyPayload() bool {
+	return AcceptLegacyPayload && !g.RejectLegacyPayload
+}
+
+// Prefixes of the version 2 flow cookie format.
+// Version 1 is the legacy concatenation of the state and the session.
+const (
+	payloadPrefix           = "~2:"
+	payloadCompressedPrefix = "~2z:"
+)
+
+// payload is the content of the flow cookie.
+type payload struct {
+	State    string            `json:"state"`
+	Provider string            `json:"provider"`
+	Tenant   string            `json:"tenant,omitempty"`
+	IssuedAt int64             `json:"iat"`
+	Session  string            `json:"session"`
+	Extras   map[string]string `json:"extras,omitempty"`
+
+	// legacy is set when the payload is decoded from the legacy format,
+	// which does not record Provider, Tenant and IssuedAt.
+	legacy bool
+}
+
+// encode returns the payload in the versioned format, which starts with a
+// marker that never appears at the start of the legacy format.
+func (p *payload) encode(compress bool) (string, error) {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return "", err
+	}
+	plain := payloadPrefix + string(b)
+	if !compress {
+		return plain, nil
+	}
+
+	var buf bytes.Buffer
+	fw, err := flate.NewWriter(&buf, flate.BestCompression)
+	if err != nil {
+		return "", err
+	}
+	fw.Write(b)
+	if err = fw.Close(); err != nil {
+		return "", err
+	}
+	compressed := payloadCompressedPrefix + base64.RawURLEncoding.EncodeToString(buf.Bytes())
+	if len(compressed) >= len(plain) {
+		return plain, nil
+	}
+	return compressed, nil
+}
+
+// decodePayload decodes both the versioned and the legacy format.
+func decodePayload(s string) (*payload, error) {
+	switch {
+	case strings.HasPrefix(s, payloadPrefix):
+		s = s[len(payloadPrefix):]
+	case strings.HasPrefix(s, payloadCompressedPrefix):
+		b, err := base64.RawURLEncoding.DecodeString(s[len(payloadCompressedPrefix):])
+		if err != nil {
+			return nil, err
+		}
+		b, err = ioutil.ReadAll(flate.NewReader(bytes.NewReader(b)))
+		if err != nil {
+			return nil, err
+		}
+		s = string(b)
+	case strings.HasPrefix(s, "~"):
+		return nil, fmt.Errorf("gothic: unsupported flow cookie version %q", strings.SplitN(s, ":", 2)[0])
+	default:
+		if len(s) < stateLen {
+			return nil, errors.New("oauth 2.0 state parameter does not match")
+		}
+		return &payload{State: s[:stateLen], Session: s[stateLen:], legacy: true}, nil
+	}
+
+	p := &payload{}
+	if err := json.Unmarshal([]byte(s), p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
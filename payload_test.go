@@ -0,0 +1,102 @@
+package gothic
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/securecookie"
+)
+
+func TestPayload(t *testing.T) {
+	p := &payload{
+		State:    "0123456789abcdef",
+		Provider: providerName,
+		Tenant:   "a",
+		IssuedAt: 1234567890,
+		Session:  strings.Repeat(`{"AccessToken":""}`, 16),
+		Extras:   map[string]string{"k": "v"},
+	}
+	for _, compress := range []bool{false, true} {
+		s, err := p.encode(compress)
+		if err != nil {
+			t.Fatal(err)
+		}
+		prefix := payloadPrefix
+		if compress {
+			prefix = payloadCompressedPrefix
+		}
+		if !strings.HasPrefix(s, prefix) {
+			t.Errorf("expected prefix %q got %q", prefix, s)
+		}
+		p2, err := decodePayload(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(p, p2) {
+			t.Errorf("expected %+v got %+v", p, p2)
+		}
+	}
+}
+
+func TestPayloadIncompressible(t *testing.T) {
+	p := &payload{State: "0123456789abcdef"}
+	s, err := p.encode(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(s, payloadPrefix) {
+		t.Errorf("expected the uncompressed format got %q", s)
+	}
+}
+
+func TestPayloadLegacy(t *testing.T) {
+	p, err := decodePayload("0123456789abcdef{}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &payload{State: "0123456789abcdef", Session: "{}", legacy: true}
+	if !reflect.DeepEqual(p, want) {
+		t.Errorf("expected %+v got %+v", want, p)
+	}
+
+	if _, err = decodePayload("~9:{}"); err == nil || !strings.Contains(err.Error(), "unsupported flow cookie version") {
+		t.Errorf("expected unsupported version error got %v", err)
+	}
+}
+
+func TestCompleteAuthLegacyCookie(t *testing.T) {
+	sess := (&mockSession{Email: userEmail, Name: userName, NickName: userNickName, AccessToken: userAccessToken}).Marshal()
+	encoded, err := securecookie.EncodeMulti(CookieName, "0123456789abcdef"+sess, defaultCodecs()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, r := wr("GET", "/?state=0123456789abcdef", nil)
+	r.Header.Set("Cookie", CookieName+"="+encoded)
+	user, err := CompleteAuth(providerName, w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifyUser(t, user)
+
+	g := &Gothic{RejectLegacyPayload: true}
+	w, r = wr("GET", "/?state=0123456789abcdef", nil)
+	r.Header.Set("Cookie", CookieName+"="+encoded)
+	var ce *FlowCookieError
+	if _, err = g.CompleteAuth(providerName, w, r); !errors.As(err, &ce) || ce.Err != ErrLegacyPayload {
+		t.Errorf("expected %v got %v", ErrLegacyPayload, err)
+	}
+}
+
+func TestCompleteAuthCompressed(t *testing.T) {
+	g := &Gothic{CompressPayload: true}
+	cookie := gothicCookie(g, providerName)
+	w, r := wr("GET", "/?state="+lastState, nil)
+	r.Header.Set("Cookie", cookie)
+	user, err := g.CompleteAuth(providerName, w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifyUser(t, user)
+}
@@ -0,0 +1,182 @@
+package gothic
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/markbates/goth"
+)
+
+// AccessPolicy admits the users of Gothic instances without AccessPolicy.
+// Every user is admitted when nil.
+var AccessPolicy Policy
+
+// ErrAccessDenied is matched by errors.Is for every *AccessDeniedError.
+var ErrAccessDenied = errors.New("gothic: access denied")
+
+// AccessDeniedError is returned by CompleteAuth when the policy does not
+// admit the user.
+type AccessDeniedError struct {
+	// Reason tells which policy denied the user.
+	Reason string
+}
+
+func (e *AccessDeniedError) Error() string {
+	return ErrAccessDenied.Error() + ": " + e.Reason
+}
+
+// Is reports whether target is ErrAccessDenied.
+func (e *AccessDeniedError) Is(target error) bool {
+	return target == ErrAccessDenied
+}
+
+func denied(reason string) error {
+	return &AccessDeniedError{Reason: reason}
+}
+
+// Policy decides whether a user fetched by CompleteAuth is admitted.
+type Policy interface {
+	// Check returns nil to admit the user, and an *AccessDeniedError or an
+	// error of the policy otherwise.
+	Check(user goth.User) error
+}
+
+// PolicyFunc adapts a function to Policy.
+type PolicyFunc func(user goth.User) error
+
+// Check implements Policy.
+func (f PolicyFunc) Check(user goth.User) error {
+	return f(user)
+}
+
+// AnyOf admits the users admitted by one of the policies.
+func AnyOf(policies ...Policy) Policy {
+	return PolicyFunc(func(user goth.User) error {
+		var reasons []string
+		for _, p := range policies {
+			err := p.Check(user)
+			if err == nil {
+				return nil
+			}
+			var ade *AccessDeniedError
+			if !errors.As(err, &ade) {
+				return err
+			}
+			reasons = append(reasons, ade.Reason)
+		}
+		return denied(strings.Join(reasons, "; "))
+	})
+}
+
+// AllOf admits the users admitted by all of the policies.
+func AllOf(policies ...Policy) Policy {
+	return PolicyFunc(func(user goth.User) error {
+		for _, p := range policies {
+			if err := p.Check(user); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// EmailDomains admits the users with an email address in one of the domains.
+// The address must be verified by the provider unless AllowUnverified is
+// set: the email_verified claim of OpenID Connect or the verified_email field
+// of the Google userinfo v2 endpoint, used by the google and gplus providers,
+// must be true in RawData.
+type EmailDomains struct {
+	Domains         []string
+	AllowUnverified bool
+}
+
+// Check implements Policy.
+func (p EmailDomains) Check(user goth.User) error {
+	at := strings.LastIndex(user.Email, "@")
+	if at < 0 {
+		return denied("no email address")
+	}
+	if !p.AllowUnverified && !rawBool(user.RawData, "email_verified") && !rawBool(user.RawData, "verified_email") {
+		return denied("email address is not verified")
+	}
+	domain := user.Email[at+1:]
+	for _, d := range p.Domains {
+		if strings.EqualFold(domain, d) {
+			return nil
+		}
+	}
+	return denied("email domain is not one of " + strings.Join(p.Domains, ", "))
+}
+
+// Users admits the users of Provider with one of the IDs or nick names,
+// such as GitHub usernames. Nick names are compared case-insensitively.
+// When Provider is empty, the IDs of any provider are compared but nick names
+// are not: they are chosen by the users at many providers, such as the
+// preferred_username of OpenID Connect.
+type Users struct {
+	Provider  string
+	IDs       []string
+	NickNames []string
+}
+
+// Check implements Policy.
+func (p Users) Check(user goth.User) error {
+	if p.Provider == "" {
+		if contains(p.IDs, user.UserID) {
+			return nil
+		}
+		return denied("user is not listed")
+	}
+	if p.Provider == user.Provider {
+		if contains(p.IDs, user.UserID) {
+			return nil
+		}
+		for _, n := range p.NickNames {
+			if user.NickName != "" && strings.EqualFold(user.NickName, n) {
+				return nil
+			}
+		}
+	}
+	return denied("user is not listed for " + p.Provider)
+}
+
+// GoogleHostedDomain admits the Google Workspace users of one of the
+// domains, which Google sends in the hd claim.
+type GoogleHostedDomain []string
+
+// Check implements Policy.
+func (p GoogleHostedDomain) Check(user goth.User) error {
+	hd, _ := user.RawData["hd"].(string)
+	for _, d := range p {
+		if hd != "" && strings.EqualFold(hd, d) {
+			return nil
+		}
+	}
+	return denied("hosted domain is not one of " + strings.Join(p, ", "))
+}
+
+func contains(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func rawBool(raw map[string]interface{}, key string) bool {
+	switch v := raw[key].(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	}
+	return false
+}
+
+func (g *Gothic) accessPolicy() Policy {
+	if g.AccessPolicy != nil {
+		return g.AccessPolicy
+	}
+	return AccessPolicy
+}
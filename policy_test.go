@@ -0,0 +1,83 @@
+package gothic
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/markbates/goth"
+)
+
+func TestPolicies(t *testing.T) {
+	verified := map[string]interface{}{"email_verified": true}
+	github := Users{Provider: "github", NickNames: []string{"Octocat"}}
+	company := EmailDomains{Domains: []string{"company.com"}}
+	for _, c := range []struct {
+		policy Policy
+		user   goth.User
+		reason string
+	}{
+		{company, goth.User{Email: "a@Company.com", RawData: verified}, ""},
+		{company, goth.User{Email: "a@company.com"}, "email address is not verified"},
+		// the shape of the Google userinfo v2 endpoint
+		{company, goth.User{Provider: "google", Email: "a@company.com", RawData: map[string]interface{}{"verified_email": true, "hd": "company.com"}}, ""},
+		{company, goth.User{Provider: "google", Email: "a@company.com", RawData: map[string]interface{}{"verified_email": false}}, "email address is not verified"},
+		{company, goth.User{Email: "a@other.com", RawData: verified}, "email domain is not one of company.com"},
+		{EmailDomains{Domains: []string{"company.com"}, AllowUnverified: true}, goth.User{Email: "a@company.com"}, ""},
+		{github, goth.User{Provider: "github", NickName: "octocat"}, ""},
+		{github, goth.User{Provider: "gitlab", NickName: "octocat"}, "user is not listed for github"},
+		{Users{IDs: []string{"42"}}, goth.User{UserID: "42"}, ""},
+		{Users{NickNames: []string{"octocat"}}, goth.User{Provider: "oidc", NickName: "octocat"}, "user is not listed"},
+		{GoogleHostedDomain{"company.com"}, goth.User{RawData: map[string]interface{}{"hd": "company.com"}}, ""},
+		{GoogleHostedDomain{"company.com"}, goth.User{}, "hosted domain is not one of company.com"},
+		{AnyOf(company, github), goth.User{Provider: "github", NickName: "octocat"}, ""},
+		{AnyOf(company, github), goth.User{Provider: "github", Email: "a@other.com", RawData: verified}, "email domain is not one of company.com; user is not listed for github"},
+		{AllOf(company, GoogleHostedDomain{"company.com"}), goth.User{Email: "a@company.com", RawData: verified}, "hosted domain is not one of company.com"},
+	} {
+		err := c.policy.Check(c.user)
+		if c.reason == "" {
+			if err != nil {
+				t.Errorf("%+v: expected to be admitted got %v", c.user, err)
+			}
+			continue
+		}
+		var ade *AccessDeniedError
+		if !errors.As(err, &ade) || ade.Reason != c.reason {
+			t.Errorf("%+v: expected reason %q got %v", c.user, c.reason, err)
+		}
+	}
+
+	// errors of the policies are not reasons
+	failing := PolicyFunc(func(goth.User) error { return errors.New("lookup failed") })
+	if err := AnyOf(company, failing).Check(goth.User{}); errors.Is(err, ErrAccessDenied) {
+		t.Errorf("expected the error of the policy got %v", err)
+	}
+}
+
+func TestCompleteAuthAccessPolicy(t *testing.T) {
+	h := newTestHandler(NoRecovery)
+	h.Gothic = &Gothic{AccessPolicy: Users{Provider: providerName, NickNames: []string{"someone"}}}
+
+	w, r := wr("GET", "/auth/"+providerName, nil)
+	h.ServeHTTP(w, r)
+	sc := w.Header().Get("Set-Cookie")
+
+	w, r = wr("GET", "/auth/"+providerName+"/callback?state="+lastState, nil)
+	r.Header.Set("Cookie", sc[:strings.Index(sc, ";")])
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "user is not listed for "+providerName) {
+		t.Errorf("expected %d got %d %s", http.StatusForbidden, w.Code, w.Body.String())
+	}
+
+	h.Gothic.AccessPolicy = Users{Provider: providerName, NickNames: []string{userNickName}}
+	w, r = wr("GET", "/auth/"+providerName, nil)
+	h.ServeHTTP(w, r)
+	sc = w.Header().Get("Set-Cookie")
+	w, r = wr("GET", "/auth/"+providerName+"/callback?state="+lastState, nil)
+	r.Header.Set("Cookie", sc[:strings.Index(sc, ";")])
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Body.String() != userEmail {
+		t.Errorf("expected the user to be admitted got %d %s", w.Code, w.Body.String())
+	}
+}
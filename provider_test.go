@@ -9,7 +9,9 @@ import (
 
 const (
 	providerName    = "mock"
+	oauth1Name      = "mock1"
 	authURL         = "http://example.com/auth/"
+	requestToken    = "reqt0ken"
 	userAccessToken = "mokken"
 	userEmail       = "mocker@example.com"
 	userName        = "mock'n'role"
@@ -41,7 +43,33 @@ func (p *mockProvider) FetchUser(s goth.Session) (goth.User, error) {
 
 func (p *mockProvider) Debug(debug bool) {}
 
+type mockOAuth1Provider struct {
+	mockProvider
+}
+
+func (p *mockOAuth1Provider) Name() string {
+	return oauth1Name
+}
+
+func (p *mockOAuth1Provider) Protocol() Protocol {
+	return OAuth1
+}
+
+func (p *mockOAuth1Provider) BeginAuth(state string) (goth.Session, error) {
+	s, err := p.mockProvider.BeginAuth(state)
+	s.(*mockSession).AuthURL = authURL + "?oauth_token=" + requestToken
+	return s, err
+}
+
+func (p *mockOAuth1Provider) FetchUser(s goth.Session) (goth.User, error) {
+	u, err := p.mockProvider.FetchUser(s)
+	u.Provider = p.Name()
+	return u, err
+}
+
 type mockSession struct {
+	AuthURL     string `json:",omitempty"`
+	Padding     string `json:",omitempty"`
 	AccessToken string
 	Email       string
 	Name        string
@@ -49,6 +77,9 @@ type mockSession struct {
 }
 
 func (s *mockSession) GetAuthURL() (string, error) {
+	if s.AuthURL != "" {
+		return s.AuthURL, nil
+	}
 	return authURL, nil
 }
 
@@ -0,0 +1,286 @@
+package gothic
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/markbates/goth"
+)
+
+// TrustedProxies are the reverse proxies whose forwarding headers are used by
+// Gothic instances without TrustedProxies.
+var TrustedProxies Proxies
+
+// ProxyHeader is the family of forwarding headers set by reverse proxies.
+type ProxyHeader int
+
+const (
+	// XForwardedHeaders are X-Forwarded-For, X-Forwarded-Proto and
+	// X-Forwarded-Host.
+	XForwardedHeaders ProxyHeader = iota
+	// ForwardedHeader is the Forwarded header of RFC 7239.
+	ForwardedHeader
+)
+
+// Proxies are reverse proxies setting one family of forwarding headers. The
+// headers of the other family are ignored, since the proxies pass them
+// through from the client unchanged.
+type Proxies struct {
+	// Networks are the networks of the proxies.
+	Networks []*net.IPNet
+	// Header is the family of forwarding headers set by the proxies.
+	Header ProxyHeader
+	// ObfuscatedIdentifiers tells clients apart by the obfuscated identifiers
+	// of RFC 7239, such as for=_hidden, when the proxies do not disclose the
+	// addresses. Only set it when the proxies replace any identifier sent by
+	// the client, since every identifier is a new client for rate limits.
+	ObfuscatedIdentifiers bool
+}
+
+// ParseProxies parses a list of CIDRs or IP addresses of proxies setting the
+// X-Forwarded-* headers.
+func ParseProxies(addrs ...string) (Proxies, error) {
+	var ns []*net.IPNet
+	for _, a := range addrs {
+		if !strings.Contains(a, "/") {
+			ip := net.ParseIP(a)
+			if ip == nil {
+				return Proxies{}, &net.ParseError{Type: "IP address", Text: a}
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			ns = append(ns, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(a)
+		if err != nil {
+			return Proxies{}, err
+		}
+		ns = append(ns, n)
+	}
+	return Proxies{Networks: ns}, nil
+}
+
+// Trusts reports whether the peer of r is one of the proxies.
+func (ps Proxies) Trusts(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return ps.contains(net.ParseIP(host))
+}
+
+func (ps Proxies) contains(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range ps.Networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ExternalURL returns the scheme and the host of r as requested by the
+// client. The forwarding headers of Header are only used when the peer of r
+// is one of the proxies. Proxies append to the headers, so the client
+// controls their first values: the element of the Forwarded header added by
+// the proxy facing the client is used, and the last values of
+// X-Forwarded-Proto and X-Forwarded-Host, which are set by the nearest proxy.
+// A forwarded host which is not a valid host[:port] is ignored.
+func (ps Proxies) ExternalURL(r *http.Request) *url.URL {
+	u := &url.URL{Scheme: "http", Host: r.Host}
+	if r.TLS != nil {
+		u.Scheme = "https"
+	}
+	if !ps.Trusts(r) {
+		return u
+	}
+
+	var scheme, host string
+	switch ps.Header {
+	case ForwardedHeader:
+		if elems := forwarded(r); len(elems) > 0 {
+			e := elems[ps.clientIndex(elems)]
+			scheme, host = e["proto"], e["host"]
+		}
+	default:
+		scheme, host = lastHeaderValue(r, "X-Forwarded-Proto"), lastHeaderValue(r, "X-Forwarded-Host")
+	}
+	if scheme = strings.ToLower(scheme); scheme == "http" || scheme == "https" {
+		u.Scheme = scheme
+	}
+	if validHost(host) {
+		u.Host = host
+	}
+	return u
+}
+
+// validHost reports whether host is a host name or an IP address with an
+// optional port.
+func validHost(host string) bool {
+	name := host
+	if h, port, err := net.SplitHostPort(host); err == nil {
+		if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			return false
+		}
+		name = h
+	} else if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		name = host[1 : len(host)-1]
+	}
+	if strings.Contains(name, ":") {
+		// IPv6 addresses must be bracketed
+		return name != host && net.ParseIP(name) != nil
+	}
+	if name == "" || len(name) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '_') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// forwarded returns the elements of the Forwarded headers of r, each mapping
+// the lower-cased parameter names to their unquoted values.
+func forwarded(r *http.Request) []map[string]string {
+	var elems []map[string]string
+	for _, h := range r.Header.Values("Forwarded") {
+		for _, e := range strings.Split(h, ",") {
+			m := map[string]string{}
+			for _, pair := range strings.Split(e, ";") {
+				kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+				if len(kv) == 2 {
+					m[strings.ToLower(kv[0])] = strings.Trim(kv[1], `"`)
+				}
+			}
+			elems = append(elems, m)
+		}
+	}
+	return elems
+}
+
+// clientIndex returns the index of the element of the Forwarded header added
+// by the proxy facing the client, which is the last element whose for
+// parameter is not one of the proxies.
+func (ps Proxies) clientIndex(elems []map[string]string) int {
+	for i := len(elems) - 1; i > 0; i-- {
+		if !ps.contains(parseHostIP(elems[i]["for"])) {
+			return i
+		}
+	}
+	return 0
+}
+
+// parseHostIP parses an address with an optional port, returning nil for
+// obfuscated identifiers such as "unknown".
+func parseHostIP(addr string) net.IP {
+	addr = strings.TrimSpace(addr)
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	return net.ParseIP(strings.Trim(addr, "[]"))
+}
+
+func lastHeaderValue(r *http.Request, name string) string {
+	vs := strings.Split(strings.Join(r.Header.Values(name), ","), ",")
+	return strings.TrimSpace(vs[len(vs)-1])
+}
+
+// ClientIP returns the address of the client of r. The Forwarded or
+// X-Forwarded-For header named by Header is only used when the peer of r is
+// one of the proxies. Proxies append to the headers, so the client controls
+// their first values: the last address which is not one of the proxies is
+// used. It returns nil when the address is unknown.
+func (ps Proxies) ClientIP(r *http.Request) net.IP {
+	return net.ParseIP(ps.clientAddr(r))
+}
+
+// clientAddr returns the address of the client of r like ClientIP, or the
+// identifier of the client when the proxy does not disclose its address and
+// ObfuscatedIdentifiers is set. Otherwise a forwarded value which is not an
+// address is ignored.
+func (ps Proxies) clientAddr(r *http.Request) string {
+	var addr string
+	switch {
+	case !ps.Trusts(r):
+	case ps.Header == ForwardedHeader:
+		if elems := forwarded(r); len(elems) > 0 {
+			addr = elems[ps.clientIndex(elems)]["for"]
+		}
+	default:
+		if vs := r.Header.Values("X-Forwarded-For"); len(vs) > 0 {
+			addrs := strings.Split(strings.Join(vs, ","), ",")
+			i := len(addrs) - 1
+			for i > 0 && ps.contains(parseHostIP(addrs[i])) {
+				i--
+			}
+			addr = addrs[i]
+		}
+	}
+	if addr = strings.TrimSpace(addr); addr != "" && !ps.ObfuscatedIdentifiers && parseHostIP(addr) == nil {
+		addr = ""
+	}
+	if addr == "" {
+		addr = r.RemoteAddr
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	return strings.Trim(addr, "[]")
+}
+
+// CallbackProvider is implemented by providers which can use a callback URL
+// computed for each flow, such as OAuth2Provider without CallbackURL.
+type CallbackProvider interface {
+	goth.Provider
+	BeginAuthWithCallback(state, callbackURL string) (goth.Session, error)
+}
+
+func (g *Gothic) proxies() Proxies {
+	if g.TrustedProxies.Networks != nil {
+		return g.TrustedProxies
+	}
+	return TrustedProxies
+}
+
+// CallbackURL returns the callback URL of providerName for r, which is
+// BaseURL of the tenant or the external URL of r followed by
+// /auth/{provider}/callback.
+func (g *Gothic) CallbackURL(r *http.Request, providerName string) (string, error) {
+	t, err := g.tenant(r)
+	if err != nil {
+		return "", err
+	}
+	return g.callbackURL(r, t, providerName), nil
+}
+
+func (g *Gothic) callbackURL(r *http.Request, t *Tenant, providerName string) string {
+	if t != nil && t.BaseURL != "" {
+		return t.CallbackURL(providerName)
+	}
+	return (&Tenant{BaseURL: g.proxies().ExternalURL(r).String()}).CallbackURL(providerName)
+}
+
+// requestCookieOptions returns the cookie options for r, which are Secure
+// when r is requested over HTTPS.
+func (g *Gothic) requestCookieOptions(c *config, t *Tenant, r *http.Request) Options {
+	co := g.cookieOptions(c, t)
+	if g.proxies().ExternalURL(r).Scheme == "https" {
+		co.Secure = true
+	}
+	return co
+}
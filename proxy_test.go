@@ -0,0 +1,133 @@
+package gothic
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParseProxies(t *testing.T) {
+	ps, err := ParseProxies("10.0.0.0/8", "192.0.2.1", "::1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for addr, want := range map[string]bool{
+		"10.1.2.3:80":  true,
+		"192.0.2.1:80": true,
+		"192.0.2.2:80": false,
+		"[::1]:80":     true,
+		"invalid":      false,
+	} {
+		_, r := wr("GET", "/", nil)
+		r.RemoteAddr = addr
+		if got := ps.Trusts(r); got != want {
+			t.Errorf("%s: expected %v got %v", addr, want, got)
+		}
+	}
+
+	if _, err = ParseProxies("example.com"); err == nil {
+		t.Error("expected an error for a host name")
+	}
+}
+
+func TestExternalURL(t *testing.T) {
+	xf, _ := ParseProxies("192.0.2.0/24")
+	fw := Proxies{Networks: xf.Networks, Header: ForwardedHeader}
+	for _, c := range []struct {
+		ps      Proxies
+		remote  string
+		tls     bool
+		headers map[string]string
+		want    string
+	}{
+		{xf, "192.0.2.1:1", false, nil, "http://example.com"},
+		{xf, "192.0.2.1:1", true, nil, "https://example.com"},
+		{xf, "192.0.2.1:1", false, map[string]string{"X-Forwarded-Proto": "http, HTTPS", "X-Forwarded-Host": "evil.example.com, ext.example.com"}, "https://ext.example.com"},
+		{xf, "192.0.2.1:1", false, map[string]string{"X-Forwarded-Host": "[2001:db8::1]:8443"}, "http://[2001:db8::1]:8443"},
+		// the Forwarded header is passed through from the client
+		{xf, "192.0.2.1:1", false, map[string]string{"X-Forwarded-Proto": "https", "Forwarded": "for=_attacker;host=evil.example;proto=http"}, "https://example.com"},
+		{fw, "192.0.2.1:1", false, map[string]string{"Forwarded": `for=198.51.100.1;proto=https;host="ext.example.com:8443", for=192.0.2.2`, "X-Forwarded-Host": "ignored"}, "https://ext.example.com:8443"},
+		// elements before the one of the proxy facing the client are forged
+		{fw, "192.0.2.1:1", false, map[string]string{"Forwarded": `for=203.0.113.1;proto=http;host=evil.example.com, for=198.51.100.1;proto=https;host=ext.example.com`}, "https://ext.example.com"},
+		{xf, "192.0.2.1:1", false, map[string]string{"X-Forwarded-Proto": "javascript"}, "http://example.com"},
+		{xf, "192.0.2.1:1", false, map[string]string{"X-Forwarded-Host": "evil.example.com/path"}, "http://example.com"},
+		{xf, "192.0.2.1:1", false, map[string]string{"X-Forwarded-Host": "ext.example.com:port"}, "http://example.com"},
+		{xf, "192.0.2.1:1", false, map[string]string{"X-Forwarded-Host": "user@evil.example.com"}, "http://example.com"},
+		{xf, "198.51.100.1:1", false, map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "evil.example.com"}, "http://example.com"},
+	} {
+		_, r := wr("GET", "/", nil)
+		r.Host = "example.com"
+		r.RemoteAddr = c.remote
+		if c.tls {
+			r.TLS = &tls.ConnectionState{}
+		}
+		for k, v := range c.headers {
+			r.Header.Set(k, v)
+		}
+		if got := c.ps.ExternalURL(r).String(); got != c.want {
+			t.Errorf("%v: expected %s got %s", c.headers, c.want, got)
+		}
+	}
+}
+
+func TestSpoofedForwardedBehindProxy(t *testing.T) {
+	ps, _ := ParseProxies("192.0.2.0/24")
+	g := &Gothic{TrustedProxies: ps}
+	w, r := wr("GET", "/auth/"+providerName, nil)
+	r.Host = "app.example.com"
+	r.RemoteAddr = "192.0.2.1:1"
+	r.Header.Set("X-Forwarded-For", "203.0.113.7")
+	r.Header.Set("X-Forwarded-Proto", "https")
+	r.Header.Set("Forwarded", "for=_attacker123;host=evil.example;proto=http")
+	if u, err := g.CallbackURL(r, providerName); err != nil || u != "https://app.example.com/auth/"+providerName+"/callback" {
+		t.Errorf("expected the callback URL of the X-Forwarded headers got %q %v", u, err)
+	}
+	if ip := ps.ClientIP(r); ip.String() != "203.0.113.7" {
+		t.Errorf("expected the address of X-Forwarded-For got %v", ip)
+	}
+	if err := g.BeginAuth(providerName, w, r); err != nil {
+		t.Fatal(err)
+	}
+	if sc := w.Header().Get("Set-Cookie"); !strings.Contains(sc, "Secure") {
+		t.Errorf("expected a Secure cookie got %q", sc)
+	}
+}
+
+func TestGetAuthURLBehindProxy(t *testing.T) {
+	ps, _ := ParseProxies("192.0.2.0/24")
+	g := &Gothic{
+		TrustedProxies: ps,
+		Providers: NewRegistry(NewOAuth2Provider("dyn", OAuth2Config{
+			ClientID: "client",
+			AuthURL:  "https://provider.example.com/authorize",
+		})),
+	}
+	w, r := wr("GET", "/auth/dyn", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	r.Header.Set("X-Forwarded-Proto", "https")
+	r.Header.Set("X-Forwarded-Host", "app.example.com")
+	authURL, err := g.GetAuthURL("dyn", w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := u.Query().Get("redirect_uri"), "https://app.example.com/auth/dyn/callback"; got != want {
+		t.Errorf("expected redirect_uri %s got %s", want, got)
+	}
+	if sc := w.Header().Get("Set-Cookie"); !strings.Contains(sc, "Secure") {
+		t.Errorf("expected a Secure cookie got %q", sc)
+	}
+
+	// the callback URL of the tenant takes precedence
+	g.Tenants = func(*http.Request) (*Tenant, error) {
+		return &Tenant{ID: "a", Providers: g.Providers, BaseURL: "https://a.example.com"}, nil
+	}
+	if u, err := g.CallbackURL(r, "dyn"); err != nil || u != "https://a.example.com/auth/dyn/callback" {
+		t.Errorf("expected the callback URL of the tenant got %q %v", u, err)
+	}
+}
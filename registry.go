@@ -0,0 +1,68 @@
+package gothic
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/markbates/goth"
+)
+
+// Registry is a set of providers which is safe for concurrent use.
+// The zero value is an empty Registry ready to use.
+type Registry struct {
+	mu        sync.RWMutex
+	providers map[string]goth.Provider
+}
+
+// NewRegistry returns a new Registry holding providers.
+func NewRegistry(providers ...goth.Provider) *Registry {
+	r := &Registry{}
+	r.Add(providers...)
+	return r
+}
+
+// Add registers providers by their Name, replacing any provider already
+// registered with the same name.
+func (r *Registry) Add(providers ...goth.Provider) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.providers == nil {
+		r.providers = map[string]goth.Provider{}
+	}
+	for _, p := range providers {
+		r.providers[p.Name()] = p
+	}
+}
+
+// Remove unregisters the providers named names.
+func (r *Registry) Remove(names ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, name := range names {
+		delete(r.providers, name)
+	}
+}
+
+// Get returns the provider registered as name.
+func (r *Registry) Get(name string) (goth.Provider, error) {
+	r.mu.RLock()
+	p, ok := r.providers[name]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no provider for %s exists", name)
+	}
+	return p, nil
+}
+
+// List returns the sorted names of the registered providers.
+func (r *Registry) List() []string {
+	r.mu.RLock()
+	names := make([]string, 0, len(r.providers))
+	for name := range r.providers {
+		names = append(names, name)
+	}
+	r.mu.RUnlock()
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,107 @@
+package gothic
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/markbates/goth"
+)
+
+type namedProvider struct {
+	mockProvider
+	name string
+}
+
+func (p *namedProvider) Name() string {
+	return p.name
+}
+
+func (p *namedProvider) FetchUser(s goth.Session) (goth.User, error) {
+	u, err := p.mockProvider.FetchUser(s)
+	u.Provider = p.name
+	return u, err
+}
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry(&namedProvider{name: "b"}, &namedProvider{name: "a"})
+	if names := r.List(); !reflect.DeepEqual(names, []string{"a", "b"}) {
+		t.Errorf("expected %v got %v", []string{"a", "b"}, names)
+	}
+
+	p, err := r.Get("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name() != "a" {
+		t.Errorf("expected provider %q got %q", "a", p.Name())
+	}
+
+	r.Remove("a")
+	if _, err = r.Get("a"); err == nil {
+		t.Error("expected error got none")
+	}
+	if names := r.List(); !reflect.DeepEqual(names, []string{"b"}) {
+		t.Errorf("expected %v got %v", []string{"b"}, names)
+	}
+}
+
+func TestRegistryZeroValue(t *testing.T) {
+	var r Registry
+	if _, err := r.Get("a"); err == nil {
+		t.Error("expected error got none")
+	}
+	r.Add(&namedProvider{name: "a"})
+	if _, err := r.Get("a"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRegistryConcurrent(t *testing.T) {
+	r := NewRegistry()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := fmt.Sprint(i)
+			r.Add(&namedProvider{name: name})
+			r.Get(name)
+			r.List()
+			r.Remove(name)
+		}(i)
+	}
+	wg.Wait()
+	if names := r.List(); len(names) != 0 {
+		t.Errorf("expected empty registry got %v", names)
+	}
+}
+
+func TestGothicRegistry(t *testing.T) {
+	g := &Gothic{Providers: NewRegistry(&namedProvider{name: "registered"})}
+
+	cookie := gothicCookie(g, "registered")
+	w, r := wr("GET", "/?state="+lastState, nil)
+	r.Header.Set("Cookie", cookie)
+	user, err := g.CompleteAuth("registered", w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifyUserWith(t, "registered", user)
+
+	if _, err = goth.GetProvider("registered"); err == nil {
+		t.Error("expected provider not to be registered to goth")
+	}
+	if _, err = GetAuthURL("registered", w, r); err == nil {
+		t.Error("expected package level GetAuthURL not to see the registry")
+	}
+}
+
+func TestGothicRegistryFallback(t *testing.T) {
+	g := &Gothic{Providers: NewRegistry()}
+	w, r := wr("GET", "/", nil)
+	if _, err := g.GetAuthURL(providerName, w, r); err != nil {
+		t.Errorf("expected fallback to goth.GetProvider got %v", err)
+	}
+}
@@ -0,0 +1,153 @@
+package gothic
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/securecookie"
+)
+
+// config is the package level configuration swapped by Reload.
+// Each flow reads it once, so a request never mixes old and new values.
+type config struct {
+	codecs []securecookie.Codec
+	keys   []Keys
+	// randomKeys is set when no keys are configured and the keys are
+	// generated at startup.
+	randomKeys bool
+	// options overrides CookieOptions when not nil.
+	options *Options
+	// err is the error of loading the keys from the environment at startup,
+	// returned by the codec until keys are reloaded.
+	err error
+}
+
+var (
+	current  atomic.Value // *config
+	reloadMu sync.Mutex
+)
+
+func loadConfig() *config {
+	c, _ := current.Load().(*config)
+	if c == nil {
+		return &config{}
+	}
+	return c
+}
+
+func (c *config) cookieOptions() Options {
+	if c.options != nil {
+		return *c.options
+	}
+	return CookieOptions
+}
+
+// Reload atomically replaces the keys of the package level codecs by the keys
+// of p and the cookie options by options. A nil p or options keeps the
+// current value. Each request uses one consistent configuration.
+//
+// Cookies encoded with keys which p no longer provides do not decode, so
+// rotated keys should be provided after the new ones for a while.
+func Reload(p KeyProvider, options *Options) error {
+	var (
+		keys []Keys
+		cs   []securecookie.Codec
+		err  error
+	)
+	if p != nil {
+		keys, err = p.Keys()
+		if err != nil {
+			return err
+		}
+		if len(keys) == 0 {
+			return ErrNoKeys
+		}
+		if cs, err = CodecsFromKeys(keys); err != nil {
+			return err
+		}
+	}
+
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	c := *loadConfig()
+	if cs != nil {
+		c.codecs, c.keys, c.randomKeys, c.err = cs, keys, false, nil
+	}
+	if options != nil {
+		o := *options
+		c.options = &o
+	}
+	current.Store(&c)
+	return nil
+}
+
+// ReloadOnSignal calls reload whenever the process receives one of sig, or
+// SIGHUP when sig is empty. Errors of reload are passed to onError, which
+// may be nil. Call stop to stop handling the signals.
+func ReloadOnSignal(reload func() error, onError func(error), sig ...os.Signal) (stop func()) {
+	if len(sig) == 0 {
+		sig = []os.Signal{syscall.SIGHUP}
+	}
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sig...)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-ch:
+				runReload(reload, onError)
+			}
+		}
+	}()
+	return stopFunc(func() {
+		signal.Stop(ch)
+		close(done)
+	})
+}
+
+// WatchFile calls reload whenever the modification time or the size of the
+// file at path changes, checking every interval. It is meant for keys and
+// configuration mounted from a secret manager. Errors of reload are passed
+// to onError, which may be nil. Call stop to stop watching.
+func WatchFile(path string, interval time.Duration, reload func() error, onError func(error)) (stop func()) {
+	last, _ := os.Stat(path)
+	done := make(chan struct{})
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+			}
+			fi, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+			if last == nil || !fi.ModTime().Equal(last.ModTime()) || fi.Size() != last.Size() {
+				last = fi
+				runReload(reload, onError)
+			}
+		}
+	}()
+	return stopFunc(func() { close(done) })
+}
+
+func runReload(reload func() error, onError func(error)) {
+	if err := reload(); err != nil && onError != nil {
+		onError(err)
+	}
+}
+
+// stopFunc makes f safe to call more than once.
+func stopFunc(f func()) func() {
+	var once sync.Once
+	return func() { once.Do(f) }
+}
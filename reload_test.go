@@ -0,0 +1,154 @@
+package gothic
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/markbates/goth"
+)
+
+type staticKeys []Keys
+
+func (k staticKeys) Keys() ([]Keys, error) { return k, nil }
+
+// stateURLProvider returns the state in the auth URL instead of lastState,
+// so concurrent flows can complete.
+type stateURLProvider struct{ mockProvider }
+
+func (p *stateURLProvider) BeginAuth(state string) (goth.Session, error) {
+	return &mockSession{
+		AuthURL:     "https://example.com/auth?state=" + state,
+		Email:       userEmail,
+		Name:        userName,
+		NickName:    userNickName,
+		AccessToken: userAccessToken,
+	}, nil
+}
+
+func TestReloadCookieOptions(t *testing.T) {
+	defer current.Store(loadConfig())
+
+	if err := Reload(nil, &Options{Path: "/auth", HttpOnly: true}); err != nil {
+		t.Fatal(err)
+	}
+	cookie := beginAuthCookie()
+	w, r := wr("GET", "/?state="+lastState, nil)
+	r.Header.Set("Cookie", cookie)
+	if _, err := CompleteAuth(providerName, w, r); err != nil {
+		t.Fatal(err)
+	}
+	if sc := w.Header().Get("Set-Cookie"); !strings.Contains(sc, "Path=/auth") {
+		t.Errorf("expected the reloaded path got %q", sc)
+	}
+}
+
+func TestReloadKeys(t *testing.T) {
+	defer current.Store(loadConfig())
+
+	old := Keys{Auth: []byte("old")}
+	if err := Reload(staticKeys{old}, nil); err != nil {
+		t.Fatal(err)
+	}
+	cookie := beginAuthCookie()
+	state := lastState
+
+	if err := Reload(staticKeys{{Auth: []byte("new")}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	w, r := wr("GET", "/?state="+state, nil)
+	r.Header.Set("Cookie", cookie)
+	if _, err := CompleteAuth(providerName, w, r); err == nil {
+		t.Error("expected an error for a cookie of a removed key")
+	}
+
+	if err := Reload(staticKeys{}, nil); err != ErrNoKeys {
+		t.Errorf("expected %v got %v", ErrNoKeys, err)
+	}
+}
+
+func TestReloadConcurrent(t *testing.T) {
+	defer current.Store(loadConfig())
+
+	g := &Gothic{Providers: NewRegistry(&stateURLProvider{})}
+	pool := staticKeys{{Auth: []byte("key0")}, {Auth: []byte("key1")}, {Auth: []byte("key2")}}
+	if err := Reload(pool, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 1; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			// rotate the encoding key within the pool, which keeps decoding
+			// flows in progress
+			n := i % len(pool)
+			keys := append(append(staticKeys{}, pool[n:]...), pool[:n]...)
+			if err := Reload(keys, &Options{Path: "/", HttpOnly: true, MaxAge: i}); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	var flows sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		flows.Add(1)
+		go func() {
+			defer flows.Done()
+			for j := 0; j < 50; j++ {
+				w, r := wr("GET", "/", nil)
+				url, err := g.GetAuthURL(providerName, w, r)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				sc := w.Header().Get("Set-Cookie")
+				state := url[strings.Index(url, "state=")+len("state="):]
+				w, r = wr("GET", "/?state="+state, nil)
+				r.Header.Set("Cookie", sc[:strings.Index(sc, ";")])
+				if _, err = g.CompleteAuth(providerName, w, r); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	flows.Wait()
+	close(done)
+	wg.Wait()
+}
+
+func TestWatchFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "keys.json")
+	if err := ioutil.WriteFile(file, []byte("1"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	called := make(chan struct{}, 1)
+	stop := WatchFile(file, 10*time.Millisecond, func() error {
+		called <- struct{}{}
+		return nil
+	}, nil)
+	defer stop()
+
+	if err := ioutil.WriteFile(file, []byte("12"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-called:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected reload on change")
+	}
+	stop()
+	stop()
+}
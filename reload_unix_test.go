@@ -0,0 +1,30 @@
+//go:build !windows
+// +build !windows
+
+package gothic
+
+import (
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestReloadOnSignal(t *testing.T) {
+	errs := make(chan error, 1)
+	stop := ReloadOnSignal(func() error {
+		return ErrNoKeys
+	}, func(err error) { errs <- err }, syscall.SIGUSR1)
+	defer stop()
+
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGUSR1); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-errs:
+		if err != ErrNoKeys {
+			t.Errorf("expected %v got %v", ErrNoKeys, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected reload on signal")
+	}
+}
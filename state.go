@@ -0,0 +1,71 @@
+package gothic
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrStateReused is returned by CompleteAuth when the state of the callback
+// has already been consumed.
+var ErrStateReused = errors.New("oauth state has already been used")
+
+// StateStore remembers consumed states to reject replayed callbacks.
+//
+// Implementations backed by a shared store allow a state consumed on one
+// server instance to be rejected on every other instance.
+type StateStore interface {
+	// Consume marks state as consumed until expiry.
+	// It must be atomic and return ErrStateReused if state is already consumed.
+	Consume(state string, expiry time.Time) error
+}
+
+// States is the StateStore used by CompleteAuth.
+// A nil States disables replay protection.
+var States StateStore
+
+// StateLifetime is how long a consumed state is remembered by States.
+// It matches the default lifetime of values encoded by securecookie.
+var StateLifetime = 30 * 24 * time.Hour
+
+// MemoryStateStore is a StateStore which keeps consumed states in memory.
+// It only protects a single process. The zero value is ready to use.
+type MemoryStateStore struct {
+	mu     sync.Mutex
+	states map[string]time.Time
+	prune  int
+}
+
+// NewMemoryStateStore returns a new MemoryStateStore.
+func NewMemoryStateStore() *MemoryStateStore {
+	return &MemoryStateStore{}
+}
+
+// Consume marks state as consumed until expiry.
+func (s *MemoryStateStore) Consume(state string, expiry time.Time) error {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.states == nil {
+		s.states = map[string]time.Time{}
+		s.prune = 64
+	}
+	if exp, ok := s.states[state]; ok && now.Before(exp) {
+		return ErrStateReused
+	}
+	s.states[state] = expiry
+
+	if len(s.states) >= s.prune {
+		for k, exp := range s.states {
+			if !now.Before(exp) {
+				delete(s.states, k)
+			}
+		}
+		s.prune = len(s.states) * 2
+		if s.prune < 64 {
+			s.prune = 64
+		}
+	}
+	return nil
+}
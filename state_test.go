@@ -0,0 +1,84 @@
+package gothic
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemoryStateStore(t *testing.T) {
+	s := NewMemoryStateStore()
+	expiry := time.Now().Add(time.Minute)
+	if err := s.Consume("state", expiry); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Consume("state", expiry); err != ErrStateReused {
+		t.Errorf("expected %v got %v", ErrStateReused, err)
+	}
+	if err := s.Consume("other", expiry); err != nil {
+		t.Errorf("expected no error got %v", err)
+	}
+
+	if err := s.Consume("expired", time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Consume("expired", expiry); err != nil {
+		t.Errorf("expected expired state to be forgotten got %v", err)
+	}
+}
+
+func TestMemoryStateStoreZeroValue(t *testing.T) {
+	s := &MemoryStateStore{}
+	expiry := time.Now().Add(time.Minute)
+	if err := s.Consume("state", expiry); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Consume("state", expiry); err != ErrStateReused {
+		t.Errorf("expected %v got %v", ErrStateReused, err)
+	}
+}
+
+func TestMemoryStateStoreConcurrent(t *testing.T) {
+	s := NewMemoryStateStore()
+	expiry := time.Now().Add(time.Minute)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	consumed := 0
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if s.Consume("state", expiry) == nil {
+				mu.Lock()
+				consumed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if consumed != 1 {
+		t.Errorf("expected state to be consumed once got %d", consumed)
+	}
+}
+
+func TestCompleteAuthReplay(t *testing.T) {
+	States = NewMemoryStateStore()
+	defer func() { States = nil }()
+
+	cookie := beginAuthCookie()
+	w, r := wr("GET", "/?state="+lastState, nil)
+	r.Header.Set("Cookie", cookie)
+	user, err := CompleteAuth(providerName, w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifyUser(t, user)
+
+	w, r = wr("GET", "/?state="+lastState, nil)
+	r.Header.Set("Cookie", cookie)
+	_, err = CompleteAuth(providerName, w, r)
+	if err != ErrStateReused {
+		t.Fatalf("expected %v got %v", ErrStateReused, err)
+	}
+}
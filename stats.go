@@ -0,0 +1,116 @@
+package gothic
+
+import (
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultStats records the flows completed by Gothic instances without Stats.
+var DefaultStats = &Stats{}
+
+// StatsWindow is the window of the recent counts of Stats.
+const StatsWindow = statsBuckets * time.Minute
+
+const statsBuckets = 60
+
+// Stats counts the successes and failures of CompleteAuth by provider.
+// The zero value is ready to use.
+type Stats struct {
+	mu        sync.Mutex
+	providers map[string]*providerStats
+}
+
+type providerStats struct {
+	buckets     [statsBuckets]statsBucket
+	lastSuccess time.Time
+	lastError   string
+	lastErrorAt time.Time
+}
+
+type statsBucket struct {
+	minute           int64
+	success, failure int
+}
+
+// ProviderStats is a snapshot of the counts of a provider.
+type ProviderStats struct {
+	Provider string
+	// Success and Failure are counted within StatsWindow.
+	Success     int
+	Failure     int
+	LastSuccess time.Time
+	// LastError has tokens and codes redacted.
+	LastError   string
+	LastErrorAt time.Time
+}
+
+// Record records the result of a flow of provider.
+func (s *Stats) Record(provider string, err error) {
+	now := time.Now()
+	minute := now.Unix() / 60
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.providers == nil {
+		s.providers = map[string]*providerStats{}
+	}
+	ps := s.providers[provider]
+	if ps == nil {
+		ps = &providerStats{}
+		s.providers[provider] = ps
+	}
+	b := &ps.buckets[minute%statsBuckets]
+	if b.minute != minute {
+		*b = statsBucket{minute: minute}
+	}
+	if err == nil {
+		b.success++
+		ps.lastSuccess = now
+		return
+	}
+	b.failure++
+	ps.lastError = redactError(err.Error())
+	ps.lastErrorAt = now
+}
+
+// Snapshot returns the counts of each provider sorted by name.
+func (s *Stats) Snapshot() []ProviderStats {
+	minute := time.Now().Unix() / 60
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := make([]ProviderStats, 0, len(s.providers))
+	for name, ps := range s.providers {
+		st := ProviderStats{
+			Provider:    name,
+			LastSuccess: ps.lastSuccess,
+			LastError:   ps.lastError,
+			LastErrorAt: ps.lastErrorAt,
+		}
+		for _, b := range ps.buckets {
+			if minute-b.minute < statsBuckets {
+				st.Success += b.success
+				st.Failure += b.failure
+			}
+		}
+		r = append(r, st)
+	}
+	sort.Slice(r, func(i, j int) bool { return r[i].Provider < r[j].Provider })
+	return r
+}
+
+var secretParam = regexp.MustCompile(`(?i)\b((?:access_token|refresh_token|id_token|oauth_token|oauth_verifier|code|client_secret|state)=)[^&\s"']+`)
+
+const maxErrorLen = 256
+
+// redactError removes the values of parameters carrying tokens from errors,
+// which often include the URL of the failed request.
+func redactError(s string) string {
+	s = secretParam.ReplaceAllString(s, "${1}REDACTED")
+	if len(s) > maxErrorLen {
+		s = s[:maxErrorLen] + "..."
+	}
+	return s
+}
@@ -0,0 +1,57 @@
+package gothic
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ErrUnknownTenant is returned when no tenant serves the request.
+var ErrUnknownTenant = errors.New("gothic: unknown tenant")
+
+// Tenant is a customer of a multi-tenant application with its own providers
+// and cookie domain.
+type Tenant struct {
+	// ID identifies the tenant. It is bound into the flow cookie, so that a
+	// callback cannot complete a flow started on another tenant.
+	ID string
+	// Providers holds the providers of the tenant, configured with the
+	// tenant's client credentials and callback URLs.
+	// Providers of other tenants and of goth are never used for the tenant.
+	Providers *Registry
+	// CookieDomain overrides the Domain of the cookie options when not empty.
+	CookieDomain string
+	// BaseURL is the external URL of the tenant, such as
+	// "https://tenant-a.example.com".
+	BaseURL string
+}
+
+// CallbackURL returns the callback URL of providerName on the tenant,
+// routed as "/auth/{provider}/callback" like the examples.
+func (t *Tenant) CallbackURL(providerName string) string {
+	return strings.TrimRight(t.BaseURL, "/") + "/auth/" + providerName + "/callback"
+}
+
+// TenantResolver returns the tenant serving r.
+type TenantResolver func(r *http.Request) (*Tenant, error)
+
+// HostTenants returns a TenantResolver which looks up tenants by the host
+// name of the request, ignoring the port.
+func HostTenants(tenants map[string]*Tenant) TenantResolver {
+	m := make(map[string]*Tenant, len(tenants))
+	for host, t := range tenants {
+		m[strings.ToLower(host)] = t
+	}
+	return func(r *http.Request) (*Tenant, error) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		t, ok := m[strings.ToLower(host)]
+		if !ok {
+			return nil, ErrUnknownTenant
+		}
+		return t, nil
+	}
+}
@@ -0,0 +1,90 @@
+package gothic
+
+import (
+	"strings"
+	"testing"
+)
+
+func newTenantGothic() (*Gothic, *Tenant, *Tenant) {
+	a := &Tenant{
+		ID:           "a",
+		Providers:    NewRegistry(&namedProvider{name: "google"}),
+		CookieDomain: "tenant-a.example.com",
+		BaseURL:      "https://tenant-a.example.com/",
+	}
+	b := &Tenant{
+		ID:        "b",
+		Providers: NewRegistry(&namedProvider{name: "google"}),
+	}
+	g := &Gothic{Tenants: HostTenants(map[string]*Tenant{
+		"tenant-a.example.com": a,
+		"Tenant-B.example.com": b,
+	})}
+	return g, a, b
+}
+
+func TestTenantProviders(t *testing.T) {
+	g, a, b := newTenantGothic()
+	for host, tenant := range map[string]*Tenant{"tenant-a.example.com": a, "tenant-b.example.com:8080": b} {
+		_, r := wr("GET", "http://"+host+"/", nil)
+		tt, err := g.tenant(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt != tenant {
+			t.Errorf("%s: expected tenant %q got %q", host, tenant.ID, tt.ID)
+		}
+		p, err := g.provider(tt, "google")
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, _ := tenant.Providers.Get("google")
+		if p != want {
+			t.Errorf("%s: expected the provider of tenant %q", host, tenant.ID)
+		}
+		if _, err = g.provider(tt, providerName); err == nil {
+			t.Errorf("%s: expected providers outside of the tenant to be unavailable", host)
+		}
+	}
+
+	w, r := wr("GET", "http://unknown.example.com/", nil)
+	if err := g.BeginAuth("google", w, r); err != ErrUnknownTenant {
+		t.Errorf("expected %v got %v", ErrUnknownTenant, err)
+	}
+}
+
+func TestTenantFlow(t *testing.T) {
+	g, _, _ := newTenantGothic()
+
+	w, r := wr("GET", "http://tenant-a.example.com/auth/google", nil)
+	if err := g.BeginAuth("google", w, r); err != nil {
+		t.Fatal(err)
+	}
+	sc := w.Header().Get("Set-Cookie")
+	if !strings.Contains(sc, "Domain=tenant-a.example.com") {
+		t.Errorf("expected tenant cookie domain in %q", sc)
+	}
+	cookie := sc[:strings.Index(sc, ";")]
+
+	w, r = wr("GET", "http://tenant-b.example.com/auth/google/callback?state="+lastState, nil)
+	r.Header.Set("Cookie", cookie)
+	if _, err := g.CompleteAuth("google", w, r); err == nil {
+		t.Error("expected a flow started on another tenant to be rejected")
+	}
+
+	w, r = wr("GET", "http://tenant-a.example.com/auth/google/callback?state="+lastState, nil)
+	r.Header.Set("Cookie", cookie)
+	user, err := g.CompleteAuth("google", w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifyUserWith(t, "google", user)
+}
+
+func TestTenantCallbackURL(t *testing.T) {
+	_, a, _ := newTenantGothic()
+	want := "https://tenant-a.example.com/auth/google/callback"
+	if u := a.CallbackURL("google"); u != want {
+		t.Errorf("expected %q got %q", want, u)
+	}
+}
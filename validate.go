@@ -0,0 +1,276 @@
+package gothic
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/markbates/goth"
+)
+
+// Severity is the severity of a Problem.
+type Severity int
+
+const (
+	// Warning is a problem which weakens the security or the reliability of
+	// the flow.
+	Warning Severity = iota
+	// Error is a problem which breaks the flow.
+	Error
+)
+
+func (s Severity) String() string {
+	if s == Error {
+		return "error"
+	}
+	return "warning"
+}
+
+// Problem is a problem found by Validate.
+type Problem struct {
+	Severity Severity
+	// Tenant and Provider identify the configuration of the problem, if any.
+	Tenant   string
+	Provider string
+	Message  string
+}
+
+func (p Problem) String() string {
+	var where []string
+	if p.Tenant != "" {
+		where = append(where, "tenant "+p.Tenant)
+	}
+	if p.Provider != "" {
+		where = append(where, "provider "+p.Provider)
+	}
+	if len(where) == 0 {
+		return p.Severity.String() + ": " + p.Message
+	}
+	return p.Severity.String() + ": " + strings.Join(where, ", ") + ": " + p.Message
+}
+
+// Report is the result of Validate.
+type Report struct {
+	Problems []Problem
+}
+
+func (r *Report) add(sev Severity, tenant, provider, format string, args ...interface{}) {
+	r.Problems = append(r.Problems, Problem{
+		Severity: sev,
+		Tenant:   tenant,
+		Provider: provider,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// Errors returns the problems of the Error severity.
+func (r *Report) Errors() []Problem { return r.filter(Error) }
+
+// Warnings returns the problems of the Warning severity.
+func (r *Report) Warnings() []Problem { return r.filter(Warning) }
+
+func (r *Report) filter(sev Severity) []Problem {
+	var ps []Problem
+	for _, p := range r.Problems {
+		if p.Severity == sev {
+			ps = append(ps, p)
+		}
+	}
+	return ps
+}
+
+// Err returns an error listing the errors of the report, or nil if there is
+// none. Warnings are not included.
+func (r *Report) Err() error {
+	errs := r.Errors()
+	if len(errs) == 0 {
+		return nil
+	}
+	msgs := make([]string, len(errs))
+	for i, p := range errs {
+		msgs[i] = p.String()
+	}
+	return errors.New("gothic: invalid configuration:\n" + strings.Join(msgs, "\n"))
+}
+
+// ValidateOptions describes the deployment checked by Validate.
+type ValidateOptions struct {
+	// BaseURL is the public URL of the application, which serves the
+	// callbacks at /auth/{provider}/callback.
+	// The callback URLs are not checked when it is empty.
+	BaseURL string
+	// Providers are the names of the providers which must be available.
+	Providers []string
+	// Tenants are checked in addition to the providers of the Gothic,
+	// since a TenantResolver cannot enumerate them.
+	Tenants []*Tenant
+}
+
+// Validate checks the package level configuration.
+func Validate(o ValidateOptions) *Report {
+	return defaultGothic.Validate(o)
+}
+
+// Validate checks the configuration of g and its providers, and performs an
+// encode/decode round trip of a flow cookie. It is meant to run at startup,
+// failing the deploy when the report has errors.
+func (g *Gothic) Validate(o ValidateOptions) *Report {
+	r := &Report{}
+	c := loadConfig()
+	g.validateCodec(r, c)
+
+	if len(o.Tenants) == 0 || g.Tenants == nil {
+		providers := map[string]goth.Provider{}
+		for name, p := range goth.GetProviders() {
+			providers[name] = p
+		}
+		if g.Providers != nil {
+			for _, name := range g.Providers.List() {
+				providers[name], _ = g.Providers.Get(name)
+			}
+		}
+		g.validateProviders(r, c, nil, o.BaseURL, o.Providers, providers)
+	}
+	for _, t := range o.Tenants {
+		providers := map[string]goth.Provider{}
+		if t.Providers == nil {
+			r.add(Error, t.ID, "", "has no providers")
+		} else {
+			for _, name := range t.Providers.List() {
+				providers[name], _ = t.Providers.Get(name)
+			}
+		}
+		g.validateProviders(r, c, t, t.BaseURL, o.Providers, providers)
+	}
+	return r
+}
+
+func (g *Gothic) validateCodec(r *Report, c *config) {
+	switch g.Codec.(type) {
+	case nil:
+		if len(g.Codecs) == 0 {
+			if c.err != nil {
+				r.add(Error, "", "", "cookie keys failed to load: %v", c.err)
+				return
+			}
+			if len(c.codecs) == 0 {
+				r.add(Error, "", "", "no cookie keys are configured")
+				return
+			}
+			if c.randomKeys {
+				r.add(Warning, "", "", "cookie keys are generated at startup; flows do not survive restarts nor work across instances")
+			}
+			for i, k := range c.keys {
+				if len(k.Auth) < 32 {
+					r.add(Warning, "", "", "authentication key %d (%s) is shorter than 32 bytes", i, k.Fingerprint())
+				}
+				if i == 0 && len(k.Encrypt) == 0 {
+					r.add(Warning, "", "", "the flow cookie is not encrypted; the provider session is readable by the client")
+				}
+			}
+		}
+	}
+
+	p := &payload{
+		State:    strings.Repeat("0", stateLen),
+		Provider: "validate",
+		IssuedAt: time.Now().Unix(),
+		Session:  "{}",
+	}
+	value, err := p.encode(g.CompressPayload || CompressPayload)
+	if err == nil {
+		name := g.cookieName()
+		var encoded string
+		if encoded, err = g.codec(c).Encode(name, value); err == nil {
+			if len(encoded) > g.maxCookieSize() {
+				err = ErrCookieTooLarge
+			} else if p, err = g.decode(c, name, encoded); err == nil && p.State != strings.Repeat("0", stateLen) {
+				err = errors.New("decoded state does not match")
+			}
+		}
+	}
+	if err != nil {
+		r.add(Error, "", "", "flow cookie round trip failed: %v", err)
+	}
+}
+
+func (g *Gothic) validateProviders(r *Report, c *config, t *Tenant, baseURL string, required []string, providers map[string]goth.Provider) {
+	var tenantID string
+	if t != nil {
+		tenantID = t.ID
+	}
+	if len(providers) == 0 {
+		r.add(Error, tenantID, "", "no providers are registered")
+	}
+	for _, name := range required {
+		if _, ok := providers[name]; !ok {
+			r.add(Error, tenantID, name, "is not registered")
+		}
+	}
+
+	co := g.cookieOptions(c, t)
+	if co.MaxAge < 0 {
+		r.add(Error, tenantID, "", "cookie MaxAge is negative; the flow cookie is deleted immediately")
+	}
+	if !co.HttpOnly {
+		r.add(Warning, tenantID, "", "cookie is not HttpOnly")
+	}
+	if baseURL == "" {
+		return
+	}
+	u, err := url.Parse(baseURL)
+	if err != nil || u.Host == "" {
+		r.add(Error, tenantID, "", "invalid base URL %q", baseURL)
+		return
+	}
+	if u.Scheme == "https" && !co.Secure && len(g.proxies().Networks) == 0 {
+		// requests over TLS get Secure cookies anyway, but not behind a
+		// TLS-terminating proxy which is not trusted
+		r.add(Warning, tenantID, "", "cookie is not Secure while %s is served over HTTPS; set Secure or TrustedProxies unless TLS is served directly", baseURL)
+	}
+
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	base := &Tenant{BaseURL: baseURL}
+	for _, name := range names {
+		got, ok := callbackURL(providers[name])
+		if !ok || got == "" {
+			// an empty callback URL is computed for each flow
+			continue
+		}
+		if want := base.CallbackURL(name); got != want {
+			r.add(Error, tenantID, name, "callback URL %q does not match the route %q", got, want)
+		}
+	}
+}
+
+// callbackURL returns the CallbackURL field of the provider behind p, which
+// most providers have.
+func callbackURL(p goth.Provider) (string, bool) {
+	v := reflect.ValueOf(unalias(p))
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return "", false
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return "", false
+	}
+	f, ok := v.Type().FieldByName("CallbackURL")
+	if !ok || f.Type.Kind() != reflect.String {
+		return "", false
+	}
+	fv, err := v.FieldByIndexErr(f.Index)
+	if err != nil {
+		return "", false
+	}
+	return fv.String(), true
+}
@@ -0,0 +1,83 @@
+package gothic
+
+import (
+	"strings"
+	"testing"
+)
+
+func hasProblem(r *Report, sev Severity, substr string) bool {
+	for _, p := range r.Problems {
+		if p.Severity == sev && strings.Contains(p.String(), substr) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestValidate(t *testing.T) {
+	r := Validate(ValidateOptions{Providers: []string{providerName}})
+	if err := r.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if !hasProblem(r, Warning, "generated at startup") {
+		t.Errorf("expected a warning for random keys got %v", r.Problems)
+	}
+
+	r = Validate(ValidateOptions{Providers: []string{"missing"}})
+	if !hasProblem(r, Error, "provider missing: is not registered") {
+		t.Errorf("expected an error for a missing provider got %v", r.Problems)
+	}
+}
+
+func TestValidateCallbackURL(t *testing.T) {
+	g := &Gothic{
+		CookieOptions: &Options{Path: "/", HttpOnly: true, Secure: true},
+		Providers: NewRegistry(
+			NewOAuth2Provider("good", OAuth2Config{CallbackURL: "https://example.com/auth/good/callback"}),
+			Alias("bad", NewOAuth2Provider("bad", OAuth2Config{CallbackURL: "https://example.com/callback"})),
+		),
+	}
+	r := g.Validate(ValidateOptions{BaseURL: "https://example.com"})
+	if len(r.Errors()) != 1 || !hasProblem(r, Error, `provider bad: callback URL "https://example.com/callback"`) {
+		t.Errorf("expected an error for the bad callback URL only got %v", r.Problems)
+	}
+
+	g.CookieOptions.Secure = false
+	r = g.Validate(ValidateOptions{BaseURL: "https://example.com"})
+	// requests served over TLS directly get Secure cookies anyway
+	if !hasProblem(r, Warning, "not Secure") || hasProblem(r, Error, "not Secure") {
+		t.Errorf("expected a warning for an insecure cookie got %v", r.Problems)
+	}
+}
+
+func TestValidateTenants(t *testing.T) {
+	g := &Gothic{Tenants: HostTenants(nil)}
+	r := g.Validate(ValidateOptions{
+		Tenants: []*Tenant{
+			{ID: "a", Providers: NewRegistry(&namedProvider{name: "p"})},
+			{ID: "b"},
+		},
+		Providers: []string{"p"},
+	})
+	if hasProblem(r, Error, "tenant a") {
+		t.Errorf("expected no error for tenant a got %v", r.Problems)
+	}
+	if !hasProblem(r, Error, "tenant b: has no providers") || !hasProblem(r, Error, "tenant b, provider p: is not registered") {
+		t.Errorf("expected errors for tenant b got %v", r.Problems)
+	}
+}
+
+func TestValidateRoundTrip(t *testing.T) {
+	r := (&Gothic{Codec: &JWECodec{Key: []byte("short")}}).Validate(ValidateOptions{})
+	if !hasProblem(r, Error, "round trip failed") {
+		t.Errorf("expected a round trip error got %v", r.Problems)
+	}
+	if r.Err() == nil {
+		t.Error("expected Err to fail")
+	}
+
+	r = (&Gothic{Codec: &JWTCodec{Key: []byte("short")}}).Validate(ValidateOptions{})
+	if !hasProblem(r, Error, "at least 32 bytes") {
+		t.Errorf("expected a round trip error got %v", r.Problems)
+	}
+}